@@ -0,0 +1,75 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package dnsutils
+
+import "github.com/miekg/dns"
+
+// UpgradeEDNS0 adds a default EDNS0 OPT record (4096 byte UDP size, DO bit
+// unset) to m if it doesn't already have one. It reports whether it made a
+// change.
+func UpgradeEDNS0(m *dns.Msg) bool {
+	if m.IsEdns0() != nil {
+		return false
+	}
+	m.SetEdns0(dns.DefaultMsgSize, false)
+	return true
+}
+
+// PadToMinimum pads m with an EDNS0 PADDING option (RFC 7830) so its wire
+// length is at least minLen, as required by the RFC 8467 strict
+// block-length padding policy. It upgrades m to EDNS0 first if needed. If m
+// already has a PADDING option, PadToMinimum leaves it untouched, per the
+// RFC 8467 negotiation: a client/resolver that already added PADDING gets
+// to decide its length, and a second padder resizing it would only make
+// wire-length analysis easier, not harder. If m is already at least minLen
+// bytes, PadToMinimum does nothing either: padding an already-large message
+// has no privacy benefit.
+//
+// upgraded reports whether an OPT record was added. newPadding reports
+// whether a new PADDING option was appended; it's false both when m already
+// had one and when nothing changed at all.
+func PadToMinimum(m *dns.Msg, minLen int) (upgraded, newPadding bool) {
+	if m.Len() >= minLen {
+		return false, false
+	}
+
+	opt := m.IsEdns0()
+	if opt == nil {
+		upgraded = UpgradeEDNS0(m)
+		opt = m.IsEdns0()
+	}
+
+	for _, o := range opt.Option {
+		if _, ok := o.(*dns.EDNS0_PADDING); ok {
+			return upgraded, false
+		}
+	}
+
+	// Measure the message with a zero-length padding option, then grow the
+	// padding to close the exact gap to minLen.
+	padding := new(dns.EDNS0_PADDING)
+	opt.Option = append(opt.Option, padding)
+	gap := minLen - m.Len()
+	if gap < 0 {
+		gap = 0
+	}
+	padding.Padding = make([]byte, gap)
+	return upgraded, true
+}
@@ -31,7 +31,9 @@ func TestPadToMinimum(t *testing.T) {
 		{"", q.Copy(), 128, 128, true, true},
 		{"", qLarge.Copy(), 128, qLarge.Len(), false, false},
 		{"", qEDNS0.Copy(), 128, 128, false, true},
-		{"", qPadded.Copy(), 128, 128, false, false},
+		// qPadded already carries a PADDING option: PadToMinimum must leave
+		// it alone rather than resizing it up to minLen.
+		{"", qPadded.Copy(), 128, qPadded.Len(), false, false},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -48,3 +50,27 @@ func TestPadToMinimum(t *testing.T) {
 		})
 	}
 }
+
+// TestPadToMinimum_BlockBoundaries checks, for both the query block (128)
+// and the response block (468), that padding a minimal query reaches at
+// least the block size (delta -1 and 0 both start from that minimal query),
+// and that a message already one byte over the block size is not brought
+// below it.
+func TestPadToMinimum_BlockBoundaries(t *testing.T) {
+	for _, blockLen := range []int{128, 468} {
+		for _, delta := range []int{-1, 0, 1} {
+			m := new(dns.Msg)
+			m.SetQuestion(".", dns.TypeA)
+			if delta > 0 {
+				// Already at blockLen+delta before the real call: re-padding
+				// to blockLen must not shrink it back down.
+				PadToMinimum(m, blockLen+delta)
+			}
+			PadToMinimum(m, blockLen)
+
+			if got := m.Len(); got < blockLen {
+				t.Errorf("blockLen=%d delta=%d: got length %d, want >= %d", blockLen, delta, got, blockLen)
+			}
+		}
+	}
+}
@@ -0,0 +1,69 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package cache provides a generic, pluggable key/value cache backend.
+// MemoryBackend (the default) keeps everything in process; RedisBackend and
+// TwoTierBackend let a fleet of mosdns instances share cache state.
+package cache
+
+import "time"
+
+// Backend is a generic cache store. K/V are left to the caller; the cache
+// plugin instantiates it as Backend[key, *dns.Msg].
+type Backend[K comparable, V any] interface {
+	// Get returns the value stored under key, if any, along with the
+	// storedTime/expireTime given to the Store call that put it there.
+	Get(key K) (v V, storedTime, expireTime time.Time, ok bool)
+
+	// Store saves v under key. The entry MUST NOT be returned by Get once
+	// expireTime has passed.
+	Store(key K, v V, storedTime, expireTime time.Time)
+
+	// Dump serializes the whole cache. Backends that have no local
+	// snapshot to take (e.g. a shared Redis store) return an error.
+	Dump(marshalKey func(K) ([]byte, error), marshalValue func(V) ([]byte, error)) (b []byte, n int, err error)
+
+	// LoadDump restores a cache previously produced by Dump.
+	LoadDump(b []byte, unmarshalKey func([]byte) (K, error), unmarshalValue func([]byte) (V, error)) error
+
+	// Keys returns up to limit marshaled keys (via marshalKey) whose
+	// marshaled form starts with prefix, for operator debugging (e.g. the
+	// cache plugin's /keys endpoint). limit <= 0 means no limit. It is not
+	// meant for use on a hot path.
+	Keys(prefix string, limit int, marshalKey func(K) ([]byte, error)) ([]string, error)
+
+	Len() int
+	Flush()
+	Close() error
+}
+
+// EvictionCounter is implemented by backends that drop entries to stay
+// within a capacity bound (MemoryBackend, and TwoTierBackend for its front
+// cache; RedisBackend relies on per-key TTLs instead). The cache plugin's /stats endpoint reports 0 for
+// backends that don't implement it.
+type EvictionCounter interface {
+	Evictions() uint64
+}
+
+// Opts are options shared by every Backend implementation.
+type Opts struct {
+	// Size is the maximum number of entries. Backends that do not enforce
+	// a size bound (e.g. Redis, which relies on per-key TTLs) ignore it.
+	Size int
+}
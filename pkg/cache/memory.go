@@ -0,0 +1,267 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package cache
+
+import (
+	"bytes"
+	"container/list"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type entry[K comparable, V any] struct {
+	key        K
+	value      V
+	storedTime time.Time
+	expireTime time.Time
+}
+
+const defaultMemorySize = 1024
+
+// MemoryBackend is an in-process, size-bounded LRU cache. It implements
+// Backend.
+type MemoryBackend[K comparable, V any] struct {
+	mu        sync.Mutex
+	maxSize   int
+	ll        *list.List // front = most recently used
+	elements  map[K]*list.Element
+	evictions atomic.Uint64
+}
+
+// NewMemory creates a MemoryBackend. opts.Size <= 0 defaults to 1024.
+func NewMemory[K comparable, V any](opts Opts) *MemoryBackend[K, V] {
+	size := opts.Size
+	if size <= 0 {
+		size = defaultMemorySize
+	}
+	return &MemoryBackend[K, V]{
+		maxSize:  size,
+		ll:       list.New(),
+		elements: make(map[K]*list.Element, size),
+	}
+}
+
+func (c *MemoryBackend[K, V]) Get(key K) (v V, storedTime, expireTime time.Time, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.elements[key]
+	if !ok {
+		return v, storedTime, expireTime, false
+	}
+	e := el.Value.(*entry[K, V])
+	if time.Now().After(e.expireTime) {
+		c.removeElementLocked(el)
+		return v, storedTime, expireTime, false
+	}
+	c.ll.MoveToFront(el)
+	return e.value, e.storedTime, e.expireTime, true
+}
+
+func (c *MemoryBackend[K, V]) Store(key K, v V, storedTime, expireTime time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.elements[key]; ok {
+		e := el.Value.(*entry[K, V])
+		e.value, e.storedTime, e.expireTime = v, storedTime, expireTime
+		c.ll.MoveToFront(el)
+		return
+	}
+
+	el := c.ll.PushFront(&entry[K, V]{key: key, value: v, storedTime: storedTime, expireTime: expireTime})
+	c.elements[key] = el
+
+	if c.ll.Len() > c.maxSize {
+		c.removeElementLocked(c.ll.Back())
+		c.evictions.Add(1)
+	}
+}
+
+// Evictions reports how many entries have been dropped to stay within
+// maxSize. It implements EvictionCounter.
+func (c *MemoryBackend[K, V]) Evictions() uint64 {
+	return c.evictions.Load()
+}
+
+// Delete removes key, if present. It is used by TwoTierBackend to act on
+// Redis invalidation notifications.
+func (c *MemoryBackend[K, V]) Delete(key K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.elements[key]; ok {
+		c.removeElementLocked(el)
+	}
+}
+
+func (c *MemoryBackend[K, V]) removeElementLocked(el *list.Element) {
+	c.ll.Remove(el)
+	delete(c.elements, el.Value.(*entry[K, V]).key)
+}
+
+func (c *MemoryBackend[K, V]) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
+
+func (c *MemoryBackend[K, V]) Flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ll.Init()
+	c.elements = make(map[K]*list.Element, c.maxSize)
+}
+
+func (c *MemoryBackend[K, V]) Close() error { return nil }
+
+// Keys implements Backend. It walks entries in most-recently-used order.
+func (c *MemoryBackend[K, V]) Keys(prefix string, limit int, marshalKey func(K) ([]byte, error)) ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var out []string
+	now := time.Now()
+	for el := c.ll.Front(); el != nil; el = el.Next() {
+		e := el.Value.(*entry[K, V])
+		if now.After(e.expireTime) {
+			continue
+		}
+		kb, err := marshalKey(e.key)
+		if err != nil {
+			return nil, fmt.Errorf("marshal key: %w", err)
+		}
+		ks := string(kb)
+		if len(prefix) > 0 && !strings.HasPrefix(ks, prefix) {
+			continue
+		}
+		out = append(out, ks)
+		if limit > 0 && len(out) >= limit {
+			break
+		}
+	}
+	return out, nil
+}
+
+// Dump format: a sequence of records, each
+// keyLen(4B) | key | storedTime(8B unix) | expireTime(8B unix) | valueLen(4B) | value.
+func (c *MemoryBackend[K, V]) Dump(marshalKey func(K) ([]byte, error), marshalValue func(V) ([]byte, error)) ([]byte, int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	buf := new(bytes.Buffer)
+	n := 0
+	for el := c.ll.Front(); el != nil; el = el.Next() {
+		e := el.Value.(*entry[K, V])
+		if time.Now().After(e.expireTime) {
+			continue
+		}
+		kb, err := marshalKey(e.key)
+		if err != nil {
+			return nil, 0, fmt.Errorf("marshal key: %w", err)
+		}
+		vb, err := marshalValue(e.value)
+		if err != nil {
+			return nil, 0, fmt.Errorf("marshal value: %w", err)
+		}
+
+		writeUint32(buf, uint32(len(kb)))
+		buf.Write(kb)
+		writeUint64(buf, uint64(e.storedTime.Unix()))
+		writeUint64(buf, uint64(e.expireTime.Unix()))
+		writeUint32(buf, uint32(len(vb)))
+		buf.Write(vb)
+		n++
+	}
+	return buf.Bytes(), n, nil
+}
+
+func (c *MemoryBackend[K, V]) LoadDump(b []byte, unmarshalKey func([]byte) (K, error), unmarshalValue func([]byte) (V, error)) error {
+	r := bytes.NewReader(b)
+	for r.Len() > 0 {
+		klen, err := readUint32(r)
+		if err != nil {
+			return fmt.Errorf("read key length: %w", err)
+		}
+		kb := make([]byte, klen)
+		if _, err := io.ReadFull(r, kb); err != nil {
+			return fmt.Errorf("read key: %w", err)
+		}
+		storedUnix, err := readUint64(r)
+		if err != nil {
+			return fmt.Errorf("read stored time: %w", err)
+		}
+		expireUnix, err := readUint64(r)
+		if err != nil {
+			return fmt.Errorf("read expire time: %w", err)
+		}
+		vlen, err := readUint32(r)
+		if err != nil {
+			return fmt.Errorf("read value length: %w", err)
+		}
+		vb := make([]byte, vlen)
+		if _, err := io.ReadFull(r, vb); err != nil {
+			return fmt.Errorf("read value: %w", err)
+		}
+
+		key, err := unmarshalKey(kb)
+		if err != nil {
+			return fmt.Errorf("unmarshal key: %w", err)
+		}
+		value, err := unmarshalValue(vb)
+		if err != nil {
+			return fmt.Errorf("unmarshal value: %w", err)
+		}
+		c.Store(key, value, time.Unix(int64(storedUnix), 0), time.Unix(int64(expireUnix), 0))
+	}
+	return nil
+}
+
+func writeUint32(buf *bytes.Buffer, v uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	buf.Write(b[:])
+}
+
+func writeUint64(buf *bytes.Buffer, v uint64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	buf.Write(b[:])
+}
+
+func readUint32(r *bytes.Reader) (uint32, error) {
+	var b [4]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint32(b[:]), nil
+}
+
+func readUint64(r *bytes.Reader) (uint64, error) {
+	var b [8]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(b[:]), nil
+}
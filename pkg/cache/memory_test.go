@@ -0,0 +1,118 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func marshalString(s string) ([]byte, error)   { return []byte(s), nil }
+func unmarshalString(b []byte) (string, error) { return string(b), nil }
+
+func TestMemoryBackend_GetStore(t *testing.T) {
+	c := NewMemory[string, string](Opts{Size: 4})
+	now := time.Now()
+
+	if _, _, _, ok := c.Get("a"); ok {
+		t.Fatal("expected miss on empty cache")
+	}
+
+	c.Store("a", "1", now, now.Add(time.Minute))
+	v, storedTime, _, ok := c.Get("a")
+	if !ok || v != "1" || !storedTime.Equal(now) {
+		t.Fatalf("unexpected get result: v=%v ok=%v", v, ok)
+	}
+}
+
+func TestMemoryBackend_Expiry(t *testing.T) {
+	c := NewMemory[string, string](Opts{Size: 4})
+	now := time.Now()
+	c.Store("a", "1", now.Add(-time.Minute), now.Add(-time.Second))
+
+	if _, _, _, ok := c.Get("a"); ok {
+		t.Fatal("expected expired entry to miss")
+	}
+	if n := c.Len(); n != 0 {
+		t.Fatalf("expected expired entry to be evicted on Get, len = %d", n)
+	}
+}
+
+func TestMemoryBackend_LRUEviction(t *testing.T) {
+	c := NewMemory[string, string](Opts{Size: 2})
+	now := time.Now()
+	c.Store("a", "1", now, now.Add(time.Minute))
+	c.Store("b", "2", now, now.Add(time.Minute))
+	c.Store("c", "3", now, now.Add(time.Minute)) // evicts "a"
+
+	if _, _, _, ok := c.Get("a"); ok {
+		t.Fatal("expected \"a\" to be evicted")
+	}
+	if n := c.Len(); n != 2 {
+		t.Fatalf("len = %d, want 2", n)
+	}
+}
+
+func TestMemoryBackend_DumpLoadDump(t *testing.T) {
+	c := NewMemory[string, string](Opts{Size: 4})
+	now := time.Now()
+	c.Store("a", "1", now, now.Add(time.Minute))
+	c.Store("b", "2", now, now.Add(time.Minute))
+
+	b, n, err := c.Dump(marshalString, marshalString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("dumped %d entries, want 2", n)
+	}
+
+	c2 := NewMemory[string, string](Opts{Size: 4})
+	if err := c2.LoadDump(b, unmarshalString, unmarshalString); err != nil {
+		t.Fatal(err)
+	}
+	if v, _, _, ok := c2.Get("a"); !ok || v != "1" {
+		t.Fatalf("loaded cache missing \"a\": v=%v ok=%v", v, ok)
+	}
+}
+
+func TestMemoryBackend_Keys(t *testing.T) {
+	c := NewMemory[string, string](Opts{Size: 4})
+	now := time.Now()
+	c.Store("example.com.", "1", now, now.Add(time.Minute))
+	c.Store("example.org.", "2", now, now.Add(time.Minute))
+	c.Store("sub.example.com.", "3", now, now.Add(time.Minute))
+
+	keys, err := c.Keys("example.com.", 0, marshalString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0] != "example.com." {
+		t.Fatalf("keys = %v, want [example.com.]", keys)
+	}
+
+	all, err := c.Keys("", 2, marshalString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 {
+		t.Fatalf("limited keys = %v, want 2 entries", all)
+	}
+}
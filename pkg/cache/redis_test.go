@@ -0,0 +1,137 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func newTestRedis(t *testing.T) *RedisBackend[string, string] {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	r, err := NewRedis[string, string](
+		RedisOpts{URL: "redis://" + mr.Addr(), Prefix: "mosdns:test:"},
+		marshalString, unmarshalString, marshalString, unmarshalString,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = r.Close() })
+	return r
+}
+
+func TestRedisBackend_GetStore(t *testing.T) {
+	r := newTestRedis(t)
+	now := time.Now()
+
+	if _, _, _, ok := r.Get("a"); ok {
+		t.Fatal("expected miss on empty cache")
+	}
+
+	r.Store("a", "1", now, now.Add(time.Minute))
+	v, storedTime, _, ok := r.Get("a")
+	if !ok || v != "1" || storedTime.Unix() != now.Unix() {
+		t.Fatalf("unexpected get result: v=%v ok=%v", v, ok)
+	}
+}
+
+func TestRedisBackend_Expired(t *testing.T) {
+	r := newTestRedis(t)
+	now := time.Now()
+	r.Store("a", "1", now, now.Add(time.Millisecond))
+	time.Sleep(10 * time.Millisecond)
+
+	if _, _, _, ok := r.Get("a"); ok {
+		t.Fatal("expected expired entry to miss")
+	}
+}
+
+func TestRedisBackend_Flush(t *testing.T) {
+	r := newTestRedis(t)
+	now := time.Now()
+	r.Store("a", "1", now, now.Add(time.Minute))
+	r.Flush()
+
+	if _, _, _, ok := r.Get("a"); ok {
+		t.Fatal("expected flushed cache to miss")
+	}
+}
+
+func TestRedisBackend_Keys(t *testing.T) {
+	r := newTestRedis(t)
+	now := time.Now()
+	r.Store("example.com.", "1", now, now.Add(time.Minute))
+	r.Store("example.org.", "2", now, now.Add(time.Minute))
+
+	keys, err := r.Keys("example.com.", 0, marshalString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0] != "example.com." {
+		t.Fatalf("keys = %v, want [example.com.]", keys)
+	}
+}
+
+func TestTwoTierBackend_FrontHitsThenInvalidates(t *testing.T) {
+	back := newTestRedis(t)
+	front := NewTwoTier[string, string](back, 16)
+	t.Cleanup(func() { _ = front.Close() })
+
+	now := time.Now()
+	front.Store("a", "1", now, now.Add(time.Minute))
+
+	if v, _, _, ok := front.Get("a"); !ok || v != "1" {
+		t.Fatalf("front get: v=%v ok=%v", v, ok)
+	}
+
+	// A second instance sharing the same Redis flushes; this instance's
+	// front cache should observe the invalidation.
+	back.Flush()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if _, _, _, ok := front.front.Get("a"); !ok {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("front cache was not invalidated after a remote flush")
+}
+
+func TestTwoTierBackend_LocalStoreDoesNotSelfEvict(t *testing.T) {
+	back := newTestRedis(t)
+	front := NewTwoTier[string, string](back, 16)
+	t.Cleanup(func() { _ = front.Close() })
+
+	now := time.Now()
+	front.Store("a", "1", now, now.Add(time.Minute))
+
+	// Give invalidateLoop time to consume the invalidation this Store just
+	// published; a self-eviction bug would delete "a" from front here.
+	deadline := time.Now().Add(200 * time.Millisecond)
+	for time.Now().Before(deadline) {
+		if _, _, _, ok := front.front.Get("a"); !ok {
+			t.Fatal("front cache self-evicted its own just-written entry")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,150 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package cache
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// TwoTierBackend fronts a RedisBackend with a small in-process
+// MemoryBackend, so repeat lookups for hot keys don't round-trip to Redis.
+// It subscribes to the Redis backend's invalidation channel so a Store or
+// Flush from any other instance in the fleet evicts this instance's front
+// cache too, keeping it from serving a key Redis no longer has.
+type TwoTierBackend[K comparable, V any] struct {
+	front *MemoryBackend[K, V]
+	back  *RedisBackend[K, V]
+
+	sub    *redis.PubSub
+	cancel context.CancelFunc
+
+	// selfWrites counts, per redis key, how many of this instance's own
+	// Store calls have published an invalidation we haven't seen the echo
+	// for yet. It's a counter rather than a one-shot marker: N overlapping
+	// Store calls for the same key publish N echoes, and invalidateLoop
+	// must consume all N before treating a further echo for that key as an
+	// external invalidation and evicting the front entry.
+	selfWrites sync.Map // redis key (string) -> *atomic.Int32
+}
+
+// NewTwoTier wraps back with a front MemoryBackend of the given size.
+func NewTwoTier[K comparable, V any](back *RedisBackend[K, V], frontSize int) *TwoTierBackend[K, V] {
+	ctx, cancel := context.WithCancel(context.Background())
+	t := &TwoTierBackend[K, V]{
+		front:  NewMemory[K, V](Opts{Size: frontSize}),
+		back:   back,
+		sub:    back.cli.Subscribe(ctx, back.invalidateChannel()),
+		cancel: cancel,
+	}
+	go t.invalidateLoop(ctx)
+	return t
+}
+
+func (t *TwoTierBackend[K, V]) invalidateLoop(ctx context.Context) {
+	ch := t.sub.Channel()
+	for {
+		select {
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			if msg.Payload == "*" {
+				t.front.Flush()
+				continue
+			}
+			if v, self := t.selfWrites.Load(msg.Payload); self {
+				if v.(*atomic.Int32).Add(-1) <= 0 {
+					t.selfWrites.Delete(msg.Payload)
+				}
+				continue // echo of our own Store; front already has the new value
+			}
+			raw := strings.TrimPrefix(msg.Payload, t.back.prefix)
+			if key, err := t.back.unmarshalKey([]byte(raw)); err == nil {
+				t.front.Delete(key)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (t *TwoTierBackend[K, V]) Get(key K) (v V, storedTime, expireTime time.Time, ok bool) {
+	if v, storedTime, expireTime, ok = t.front.Get(key); ok {
+		return
+	}
+	v, storedTime, expireTime, ok = t.back.Get(key)
+	if ok {
+		t.front.Store(key, v, storedTime, expireTime)
+	}
+	return
+}
+
+func (t *TwoTierBackend[K, V]) Store(key K, v V, storedTime, expireTime time.Time) {
+	t.front.Store(key, v, storedTime, expireTime)
+	// Bump rk's self-write counter before back.Store publishes its
+	// invalidation, so invalidateLoop recognizes the echo and doesn't evict
+	// the entry we just put in front. If redisKey fails, back.Store will
+	// fail the same way and publish nothing, so there's nothing to guard
+	// against.
+	if rk, err := t.back.redisKey(key); err == nil {
+		n, _ := t.selfWrites.LoadOrStore(rk, new(atomic.Int32))
+		n.(*atomic.Int32).Add(1)
+	}
+	t.back.Store(key, v, storedTime, expireTime) // publishes the invalidation that keeps other instances' front caches honest
+}
+
+func (t *TwoTierBackend[K, V]) Len() int { return t.back.Len() }
+
+// Evictions implements EvictionCounter by forwarding to front, the only
+// tier that ever drops entries to stay within a size cap; back is Redis,
+// which reaps by TTL instead.
+func (t *TwoTierBackend[K, V]) Evictions() uint64 { return t.front.Evictions() }
+
+func (t *TwoTierBackend[K, V]) Flush() {
+	t.front.Flush()
+	t.back.Flush()
+}
+
+func (t *TwoTierBackend[K, V]) Close() error {
+	t.cancel()
+	_ = t.sub.Close()
+	return t.back.Close()
+}
+
+// Dump/LoadDump operate on the front cache only; see RedisBackend's.
+func (t *TwoTierBackend[K, V]) Dump(marshalKey func(K) ([]byte, error), marshalValue func(V) ([]byte, error)) ([]byte, int, error) {
+	return t.front.Dump(marshalKey, marshalValue)
+}
+
+func (t *TwoTierBackend[K, V]) LoadDump(b []byte, unmarshalKey func([]byte) (K, error), unmarshalValue func([]byte) (V, error)) error {
+	return t.front.LoadDump(b, unmarshalKey, unmarshalValue)
+}
+
+// Keys delegates to back, since it holds the fleet-wide set of keys; the
+// front cache only ever holds a subset.
+func (t *TwoTierBackend[K, V]) Keys(prefix string, limit int, marshalKey func(K) ([]byte, error)) ([]string, error) {
+	return t.back.Keys(prefix, limit, marshalKey)
+}
@@ -0,0 +1,152 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package dnstap
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Frame Streams (https://github.com/farsightsec/fstrm) control frame types
+// and the content type dnstap collectors expect.
+const (
+	fsControlAccept = 1
+	fsControlStart  = 2
+	fsControlStop   = 3
+	fsControlReady  = 4
+
+	dnstapContentType = "protobuf:dnstap.Dnstap"
+)
+
+// escapeFrame, per the Frame Streams spec, is an all-zero 4 byte length
+// prefix that introduces a control frame.
+var escapeFrame = []byte{0, 0, 0, 0}
+
+// handshake performs the bidirectional Frame Streams handshake over rw:
+// send READY(content-type), expect ACCEPT, send START(content-type). Once it
+// returns without error the connection is ready to carry length-prefixed
+// data frames.
+func handshake(rw io.ReadWriter) error {
+	ready := encodeControl(fsControlReady, dnstapContentType)
+	if _, err := rw.Write(ready); err != nil {
+		return fmt.Errorf("write ready: %w", err)
+	}
+	typ, _, err := readControlFrame(rw)
+	if err != nil {
+		return fmt.Errorf("read accept: %w", err)
+	}
+	if typ != fsControlAccept {
+		return fmt.Errorf("unexpected control frame type %d, want ACCEPT", typ)
+	}
+
+	start := encodeControl(fsControlStart, dnstapContentType)
+	if _, err := rw.Write(start); err != nil {
+		return fmt.Errorf("write start: %w", err)
+	}
+	return nil
+}
+
+// stopHandshake writes the Frame Streams STOP control frame. Callers
+// typically ignore its error since it best-effort notifies a peer that is
+// likely already gone.
+func stopHandshake(w io.Writer) error {
+	_, err := w.Write(encodeControl(fsControlStop, ""))
+	return err
+}
+
+func encodeControl(typ uint32, contentType string) []byte {
+	var payload []byte
+	payload = appendU32(payload, typ)
+	if len(contentType) > 0 {
+		// field type 1: FSTRM_CONTROL_FIELD_CONTENT_TYPE
+		payload = appendU32(payload, 1)
+		payload = appendU32(payload, uint32(len(contentType)))
+		payload = append(payload, contentType...)
+	}
+	out := make([]byte, 0, 8+len(payload))
+	out = append(out, escapeFrame...)
+	out = appendU32(out, uint32(len(payload)))
+	out = append(out, payload...)
+	return out
+}
+
+func readControlFrame(r io.Reader) (typ uint32, contentType string, err error) {
+	var hdr [4]byte
+	if _, err = io.ReadFull(r, hdr[:]); err != nil {
+		return 0, "", err
+	}
+	if binary.BigEndian.Uint32(hdr[:]) != 0 {
+		return 0, "", errors.New("expected escape frame (length 0)")
+	}
+	length, err := readU32(r)
+	if err != nil {
+		return 0, "", err
+	}
+	if length < 4 {
+		return 0, "", errors.New("control frame shorter than its type field")
+	}
+	buf := make([]byte, length)
+	if _, err = io.ReadFull(r, buf); err != nil {
+		return 0, "", err
+	}
+	typ = binary.BigEndian.Uint32(buf[:4])
+	buf = buf[4:]
+	for len(buf) >= 8 {
+		field := binary.BigEndian.Uint32(buf[:4])
+		flen := binary.BigEndian.Uint32(buf[4:8])
+		buf = buf[8:]
+		if uint32(len(buf)) < flen {
+			break
+		}
+		if field == 1 {
+			contentType = string(buf[:flen])
+		}
+		buf = buf[flen:]
+	}
+	return typ, contentType, nil
+}
+
+// writeDataFrame writes a single length-prefixed data frame (the payload is
+// the marshaled Dnstap message).
+func writeDataFrame(w io.Writer, payload []byte) error {
+	hdr := make([]byte, 4)
+	binary.BigEndian.PutUint32(hdr, uint32(len(payload)))
+	if _, err := w.Write(hdr); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+func appendU32(b []byte, v uint32) []byte {
+	var tmp [4]byte
+	binary.BigEndian.PutUint32(tmp[:], v)
+	return append(b, tmp[:]...)
+}
+
+func readU32(r io.Reader) (uint32, error) {
+	var tmp [4]byte
+	if _, err := io.ReadFull(r, tmp[:]); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint32(tmp[:]), nil
+}
@@ -0,0 +1,132 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package dnstap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestEncodeControl_RoundTrip(t *testing.T) {
+	b := encodeControl(fsControlStart, dnstapContentType)
+	typ, ct, err := readControlFrame(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ != fsControlStart {
+		t.Errorf("type = %d, want %d", typ, fsControlStart)
+	}
+	if ct != dnstapContentType {
+		t.Errorf("content type = %q, want %q", ct, dnstapContentType)
+	}
+}
+
+func TestEncodeControl_NoContentType(t *testing.T) {
+	b := encodeControl(fsControlStop, "")
+	typ, ct, err := readControlFrame(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ != fsControlStop || ct != "" {
+		t.Errorf("got type=%d ct=%q, want type=%d ct=\"\"", typ, ct, fsControlStop)
+	}
+}
+
+func TestReadControlFrame_RejectsNonEscapeFrame(t *testing.T) {
+	// A data frame (nonzero length prefix) is not a valid control frame.
+	b := []byte{0, 0, 0, 1, 0xAA}
+	if _, _, err := readControlFrame(bytes.NewReader(b)); err == nil {
+		t.Fatal("expected an error for a non-escape frame")
+	}
+}
+
+func TestHandshake(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- handshake(client) }()
+
+	typ, ct, err := readControlFrame(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ != fsControlReady || ct != dnstapContentType {
+		t.Fatalf("unexpected READY frame: type=%d ct=%q", typ, ct)
+	}
+	if _, err := server.Write(encodeControl(fsControlAccept, dnstapContentType)); err != nil {
+		t.Fatal(err)
+	}
+
+	typ, ct, err = readControlFrame(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ != fsControlStart || ct != dnstapContentType {
+		t.Fatalf("unexpected START frame: type=%d ct=%q", typ, ct)
+	}
+
+	if err := <-errCh; err != nil {
+		t.Fatalf("handshake() error: %v", err)
+	}
+}
+
+func TestHandshake_RejectsNonAcceptReply(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- handshake(client) }()
+
+	if _, _, err := readControlFrame(server); err != nil {
+		t.Fatal(err)
+	}
+	// Reply with STOP instead of ACCEPT.
+	if _, err := server.Write(encodeControl(fsControlStop, "")); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errCh; err == nil {
+		t.Fatal("expected handshake to reject a non-ACCEPT reply")
+	}
+}
+
+func TestWriteDataFrame(t *testing.T) {
+	var buf bytes.Buffer
+	payload := []byte("hello")
+	if err := writeDataFrame(&buf, payload); err != nil {
+		t.Fatal(err)
+	}
+
+	var hdr [4]byte
+	if _, err := io.ReadFull(&buf, hdr[:]); err != nil {
+		t.Fatal(err)
+	}
+	if got := binary.BigEndian.Uint32(hdr[:]); got != uint32(len(payload)) {
+		t.Fatalf("length prefix = %d, want %d", got, len(payload))
+	}
+	if got := buf.Bytes(); !bytes.Equal(got, payload) {
+		t.Fatalf("payload = %q, want %q", got, payload)
+	}
+}
@@ -0,0 +1,48 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package dnstap
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// dialSink opens the transport-level connection for target, which is either
+// "unix:///path/to.sock" or "tcp://host:port".
+func dialSink(target string, timeout time.Duration) (net.Conn, error) {
+	network, addr, err := parseTarget(target)
+	if err != nil {
+		return nil, err
+	}
+	return net.DialTimeout(network, addr, timeout)
+}
+
+func parseTarget(target string) (network, addr string, err error) {
+	switch {
+	case strings.HasPrefix(target, "unix://"):
+		return "unix", strings.TrimPrefix(target, "unix://"), nil
+	case strings.HasPrefix(target, "tcp://"):
+		return "tcp", strings.TrimPrefix(target, "tcp://"), nil
+	default:
+		return "", "", fmt.Errorf("dnstap: invalid target %q, want unix:// or tcp:// prefix", target)
+	}
+}
@@ -0,0 +1,46 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package dnstap
+
+import "testing"
+
+func TestParseTarget(t *testing.T) {
+	tests := []struct {
+		target      string
+		wantNetwork string
+		wantAddr    string
+		wantErr     bool
+	}{
+		{"unix:///var/run/dnstap.sock", "unix", "/var/run/dnstap.sock", false},
+		{"tcp://127.0.0.1:6000", "tcp", "127.0.0.1:6000", false},
+		{"bogus://nope", "", "", true},
+		{"", "", "", true},
+	}
+	for _, tt := range tests {
+		network, addr, err := parseTarget(tt.target)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseTarget(%q) error = %v, wantErr %v", tt.target, err, tt.wantErr)
+			continue
+		}
+		if err == nil && (network != tt.wantNetwork || addr != tt.wantAddr) {
+			t.Errorf("parseTarget(%q) = (%q, %q), want (%q, %q)", tt.target, network, addr, tt.wantNetwork, tt.wantAddr)
+		}
+	}
+}
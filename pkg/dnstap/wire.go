@@ -0,0 +1,96 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package dnstap
+
+import "google.golang.org/protobuf/encoding/protowire"
+
+// Marshal encodes d as a protobuf-wire-compatible dnstap.Dnstap message.
+// Field numbers are taken from dnstap.proto; see the doc comments on
+// Dnstap and Message.
+func (d *Dnstap) Marshal() []byte {
+	var b []byte
+	if len(d.Identity) > 0 {
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendString(b, d.Identity)
+	}
+	if len(d.Version) > 0 {
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendString(b, d.Version)
+	}
+	if len(d.Extra) > 0 {
+		b = protowire.AppendTag(b, 3, protowire.BytesType)
+		b = protowire.AppendBytes(b, d.Extra)
+	}
+	if d.Message != nil {
+		b = protowire.AppendTag(b, 14, protowire.BytesType)
+		b = protowire.AppendBytes(b, d.Message.marshal())
+	}
+	// Dnstap.type, always MESSAGE (1).
+	b = protowire.AppendTag(b, 15, protowire.VarintType)
+	b = protowire.AppendVarint(b, 1)
+	return b
+}
+
+func (m *Message) marshal() []byte {
+	var b []byte
+	b = appendVarintField(b, 1, uint64(m.Type))
+	b = appendVarintField(b, 2, uint64(m.SocketFamily))
+	b = appendVarintField(b, 3, uint64(m.SocketProtocol))
+	if len(m.QueryAddress) > 0 {
+		b = appendBytesField(b, 4, m.QueryAddress)
+	}
+	if len(m.ResponseAddress) > 0 {
+		b = appendBytesField(b, 5, m.ResponseAddress)
+	}
+	if m.QueryPort > 0 {
+		b = appendVarintField(b, 6, uint64(m.QueryPort))
+	}
+	if m.ResponsePort > 0 {
+		b = appendVarintField(b, 7, uint64(m.ResponsePort))
+	}
+	if m.QueryTimeSec > 0 {
+		b = appendVarintField(b, 8, m.QueryTimeSec)
+		b = appendVarintField(b, 9, uint64(m.QueryTimeNsec))
+	}
+	if len(m.QueryMessage) > 0 {
+		b = appendBytesField(b, 10, m.QueryMessage)
+	}
+	if len(m.QueryZone) > 0 {
+		b = appendBytesField(b, 11, m.QueryZone)
+	}
+	if m.ResponseTimeSec > 0 {
+		b = appendVarintField(b, 12, m.ResponseTimeSec)
+		b = appendVarintField(b, 13, uint64(m.ResponseTimeNsec))
+	}
+	if len(m.ResponseMessage) > 0 {
+		b = appendBytesField(b, 14, m.ResponseMessage)
+	}
+	return b
+}
+
+func appendVarintField(b []byte, num protowire.Number, v uint64) []byte {
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+func appendBytesField(b []byte, num protowire.Number, v []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, v)
+}
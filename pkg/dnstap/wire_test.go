@@ -0,0 +1,93 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package dnstap
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestMessage_Marshal_OmitsUnsetOptionalFields(t *testing.T) {
+	m := NewMessage(MessageTypeClientQuery)
+	fields := decodeFieldNumbers(t, m.marshal())
+
+	want := map[protowire.Number]bool{1: true, 2: true, 3: true}
+	for n := range fields {
+		if !want[n] {
+			t.Errorf("unexpected field %d in a message with no query/response set", n)
+		}
+	}
+}
+
+func TestMessage_Marshal_IncludesSetFields(t *testing.T) {
+	m := NewMessage(MessageTypeClientQuery)
+	m.SetQuery([]byte("query-wire"), time.Now())
+	m.SetAddrs(&net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 53}, nil)
+
+	fields := decodeFieldNumbers(t, m.marshal())
+	for _, num := range []protowire.Number{1, 2, 3, 4, 6, 8, 9, 10} {
+		if !fields[num] {
+			t.Errorf("expected field %d in marshaled message, got fields %v", num, fields)
+		}
+	}
+}
+
+func TestDnstap_Marshal_IncludesMessage(t *testing.T) {
+	d := &Dnstap{Identity: "test", Version: "1.0", Message: NewMessage(MessageTypeClientQuery)}
+	fields := decodeFieldNumbers(t, d.Marshal())
+	for _, num := range []protowire.Number{1, 2, 14, 15} {
+		if !fields[num] {
+			t.Errorf("expected field %d in marshaled Dnstap, got fields %v", num, fields)
+		}
+	}
+}
+
+// decodeFieldNumbers walks b's top-level protobuf fields and returns the set
+// of field numbers present; it does not care about their values.
+func decodeFieldNumbers(t *testing.T, b []byte) map[protowire.Number]bool {
+	t.Helper()
+	out := make(map[protowire.Number]bool)
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			t.Fatalf("invalid tag in %x", b)
+		}
+		b = b[n:]
+
+		var size int
+		switch typ {
+		case protowire.VarintType:
+			_, size = protowire.ConsumeVarint(b)
+		case protowire.BytesType:
+			_, size = protowire.ConsumeBytes(b)
+		default:
+			t.Fatalf("unexpected wire type %d for field %d", typ, num)
+		}
+		if size < 0 {
+			t.Fatalf("invalid value for field %d in %x", num, b)
+		}
+		out[num] = true
+		b = b[size:]
+	}
+	return out
+}
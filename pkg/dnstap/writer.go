@@ -0,0 +1,168 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package dnstap
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultDialTimeout  = time.Second * 5
+	maxReconnectBackoff = time.Second * 30
+	minReconnectBackoff = time.Millisecond * 200
+)
+
+// Writer asynchronously ships Dnstap frames to a single Frame Streams sink
+// (unix socket or TCP). Callers never block on network I/O: Write enqueues
+// onto a bounded channel and drops the frame, counting it, if the channel is
+// full or the writer has not managed to (re)connect yet.
+type Writer struct {
+	target   string
+	identity string
+	version  string
+	queue    chan []byte
+	logger   *zap.Logger
+
+	dropped prometheus.Counter
+	sent    prometheus.Counter
+
+	closeOnce   sync.Once
+	closeNotify chan struct{}
+	wg          sync.WaitGroup
+}
+
+// NewWriter starts a Writer delivering frames to target ("unix://..." or
+// "tcp://..."). bufSize bounds the number of pending frames kept in memory
+// while the sink is unreachable or slow; once full, new frames are dropped.
+func NewWriter(target, identity, version string, bufSize int, logger *zap.Logger, dropped, sent prometheus.Counter) *Writer {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	if bufSize <= 0 {
+		bufSize = 4096
+	}
+	w := &Writer{
+		target:      target,
+		identity:    identity,
+		version:     version,
+		queue:       make(chan []byte, bufSize),
+		logger:      logger,
+		dropped:     dropped,
+		sent:        sent,
+		closeNotify: make(chan struct{}),
+	}
+	w.wg.Add(1)
+	go w.loop()
+	return w
+}
+
+// Write enqueues m for asynchronous delivery. It never blocks.
+func (w *Writer) Write(m *Message) {
+	d := &Dnstap{Identity: w.identity, Version: w.version, Message: m}
+	if len(m.Extra) > 0 {
+		d.Extra = []byte(m.Extra)
+	}
+	frame := d.Marshal()
+	select {
+	case w.queue <- frame:
+	default:
+		if w.dropped != nil {
+			w.dropped.Inc()
+		}
+	}
+}
+
+// Close stops the delivery goroutine and closes the current connection, if
+// any. It does not flush the queue; pending frames are discarded.
+func (w *Writer) Close() error {
+	w.closeOnce.Do(func() {
+		close(w.closeNotify)
+	})
+	w.wg.Wait()
+	return nil
+}
+
+// loop owns the sink connection. It reconnects with exponential backoff and
+// re-does the Frame Streams handshake whenever the connection drops.
+func (w *Writer) loop() {
+	defer w.wg.Done()
+
+	backoff := minReconnectBackoff
+	var conn net.Conn
+	defer func() {
+		if conn != nil {
+			_ = stopHandshake(conn)
+			_ = conn.Close()
+		}
+	}()
+
+	for {
+		if conn == nil {
+			c, err := dialSink(w.target, defaultDialTimeout)
+			if err == nil {
+				if err = handshake(c); err != nil {
+					_ = c.Close()
+					c = nil
+				}
+			}
+			if err != nil {
+				w.logger.Warn("dnstap sink unavailable, will retry", zap.String("target", w.target), zap.Error(err), zap.Duration("backoff", backoff))
+				select {
+				case <-time.After(backoff):
+				case <-w.closeNotify:
+					return
+				}
+				backoff = nextBackoff(backoff)
+				continue
+			}
+			conn = c
+			backoff = minReconnectBackoff
+			w.logger.Info("dnstap sink connected", zap.String("target", w.target))
+		}
+
+		select {
+		case frame := <-w.queue:
+			if err := writeDataFrame(conn, frame); err != nil {
+				w.logger.Warn("dnstap write failed, reconnecting", zap.Error(err))
+				_ = conn.Close()
+				conn = nil
+				continue
+			}
+			if w.sent != nil {
+				w.sent.Inc()
+			}
+		case <-w.closeNotify:
+			return
+		}
+	}
+}
+
+func nextBackoff(cur time.Duration) time.Duration {
+	next := cur * 2
+	if next > maxReconnectBackoff {
+		return maxReconnectBackoff
+	}
+	return next
+}
@@ -0,0 +1,106 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package dnstap
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestWriter_ConnectsHandshakesAndDelivers drives Writer against a real unix
+// socket, playing the collector side of the Frame Streams handshake by
+// hand, and checks a written Message actually arrives as a data frame.
+func TestWriter_ConnectsHandshakesAndDelivers(t *testing.T) {
+	sockPath := filepath.Join(t.TempDir(), "dnstap.sock")
+	ln, err := net.Listen("unix", sockPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	connCh := make(chan net.Conn, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err == nil {
+			connCh <- c
+		}
+	}()
+
+	w := NewWriter("unix://"+sockPath, "test-id", "test-ver", 16, nil, nil, nil)
+	defer w.Close()
+
+	var conn net.Conn
+	select {
+	case conn = <-connCh:
+	case <-time.After(2 * time.Second):
+		t.Fatal("writer never connected")
+	}
+	defer conn.Close()
+
+	typ, ct, err := readControlFrame(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ != fsControlReady || ct != dnstapContentType {
+		t.Fatalf("unexpected READY frame: type=%d ct=%q", typ, ct)
+	}
+	if _, err := conn.Write(encodeControl(fsControlAccept, dnstapContentType)); err != nil {
+		t.Fatal(err)
+	}
+
+	typ, ct, err = readControlFrame(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ != fsControlStart || ct != dnstapContentType {
+		t.Fatalf("unexpected START frame: type=%d ct=%q", typ, ct)
+	}
+
+	m := NewMessage(MessageTypeClientQuery)
+	m.SetQuery([]byte("wire"), time.Now())
+	w.Write(m)
+
+	var hdr [4]byte
+	if _, err := io.ReadFull(conn, hdr[:]); err != nil {
+		t.Fatal(err)
+	}
+	length := binary.BigEndian.Uint32(hdr[:])
+	if length == 0 {
+		t.Fatal("expected a non-empty data frame")
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(conn, payload); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNextBackoff_CapsAtMax(t *testing.T) {
+	b := minReconnectBackoff
+	for i := 0; i < 20; i++ {
+		b = nextBackoff(b)
+	}
+	if b != maxReconnectBackoff {
+		t.Fatalf("backoff = %v, want capped at %v", b, maxReconnectBackoff)
+	}
+}
@@ -0,0 +1,145 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package query_context carries a single query through a plugin sequence.
+package query_context
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+)
+
+// TransportSecurity identifies the transport a query arrived on. It is set
+// by the server/listener layer when it builds the Context, before the
+// query is handed to the plugin sequence, so plugins downstream (e.g. pad)
+// can tell an encrypted transport from plain Do53 without re-deriving it.
+type TransportSecurity int
+
+const (
+	TransportPlain TransportSecurity = iota // plain UDP/TCP (Do53)
+	TransportTLS                            // DNS-over-TLS
+	TransportHTTPS                          // DNS-over-HTTPS
+	TransportQUIC                           // DNS-over-QUIC
+)
+
+// String implements fmt.Stringer.
+func (t TransportSecurity) String() string {
+	switch t {
+	case TransportTLS:
+		return "tls"
+	case TransportHTTPS:
+		return "https"
+	case TransportQUIC:
+		return "quic"
+	default:
+		return "plain"
+	}
+}
+
+// Context carries a query and, once produced, its response through a
+// plugin sequence, along with metadata about the query.
+type Context struct {
+	q  *dns.Msg
+	r  *dns.Msg
+	ts TransportSecurity
+
+	clientAddr net.Addr // set by the server/listener layer; nil if unknown
+
+	startTime time.Time
+}
+
+// NewContext creates a new Context for q. ts is the transport q arrived
+// on, as determined by the server/listener layer that accepted it.
+func NewContext(q *dns.Msg, ts TransportSecurity) *Context {
+	return &Context{q: q, ts: ts, startTime: time.Now()}
+}
+
+// Q returns the query message. It is never nil.
+func (c *Context) Q() *dns.Msg {
+	return c.q
+}
+
+// R returns the response message, or nil if no response has been set yet.
+func (c *Context) R() *dns.Msg {
+	return c.r
+}
+
+// SetResponse sets the response message.
+func (c *Context) SetResponse(r *dns.Msg) {
+	c.r = r
+}
+
+// TransportSecurity returns the transport the query arrived on.
+func (c *Context) TransportSecurity() TransportSecurity {
+	return c.ts
+}
+
+// SetTransportSecurity sets the transport the query arrived on. Called by
+// the server/listener layer when it builds the Context.
+func (c *Context) SetTransportSecurity(ts TransportSecurity) {
+	c.ts = ts
+}
+
+// ClientAddr returns the remote address q arrived from, or nil if the
+// server/listener layer that built this Context didn't set one (e.g. a
+// synthesized Context with no real client socket).
+func (c *Context) ClientAddr() net.Addr {
+	return c.clientAddr
+}
+
+// SetClientAddr sets the remote address q arrived from. Called by the
+// server/listener layer when it builds the Context.
+func (c *Context) SetClientAddr(addr net.Addr) {
+	c.clientAddr = addr
+}
+
+// StartTime returns the time this Context was created.
+func (c *Context) StartTime() time.Time {
+	return c.startTime
+}
+
+// Copy returns a copy of c that is safe to keep using after the original
+// request has returned, e.g. for a lazy cache update or a prefetch replay
+// that runs on after the original caller has moved on.
+func (c *Context) Copy() *Context {
+	nc := &Context{
+		q:          c.q.Copy(),
+		ts:         c.ts,
+		clientAddr: c.clientAddr,
+		startTime:  c.startTime,
+	}
+	if c.r != nil {
+		nc.r = c.r.Copy()
+	}
+	return nc
+}
+
+// InfoField returns a zap.Field summarizing the query, for use in log
+// lines.
+func (c *Context) InfoField() zap.Field {
+	if len(c.q.Question) == 0 {
+		return zap.String("query", "<empty question>")
+	}
+	question := c.q.Question[0]
+	return zap.String("query", fmt.Sprintf("%s %s", question.Name, dns.TypeToString[question.Qtype]))
+}
@@ -17,15 +17,31 @@
  * along with this program.  If not, see <https://www.gnu.org/licenses/>.
  */
 
+// Package cache implements the "cache" executable plugin: an RFC 8767
+// serve-stale DNS response cache with optional proactive prefetch of
+// about-to-expire hot entries. Storage is pluggable (backend: "memory" or
+// "redis", optionally two-tiered via redis_two_tier); see pkg/cache.
+//
+// The memory backend supports snapshotting the cache to args.DumpFile and
+// back, and the /dump and /load_dump API endpoints, which send and accept
+// the same snapshot over HTTP. The redis backend does not: the data already
+// lives centrally in Redis, so there is nothing local worth snapshotting;
+// under backend: redis, /dump responds 500 and /load_dump responds 400.
+// With redis_two_tier, snapshots cover the in-process front cache only.
+// This is an intentional scope cut, not a bug.
 package cache
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"github.com/IrineSistiana/mosdns/v5/coremain"
 	"github.com/IrineSistiana/mosdns/v5/pkg/cache"
+	"github.com/IrineSistiana/mosdns/v5/pkg/dnstap"
 	"github.com/IrineSistiana/mosdns/v5/pkg/dnsutils"
 	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
 	"github.com/IrineSistiana/mosdns/v5/pkg/utils"
+	pdnstap "github.com/IrineSistiana/mosdns/v5/plugin/executable/dnstap"
 	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
 	"github.com/go-chi/chi/v5"
 	"github.com/miekg/dns"
@@ -37,6 +53,7 @@ import (
 	"math/rand"
 	"net/http"
 	"os"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -53,65 +70,167 @@ func init() {
 const (
 	defaultLazyUpdateTimeout = time.Second * 5
 	minimumChangesToDump     = 1024
+
+	// RFC 8767 defaults/limits.
+	defaultStaleTTL                 = 86400     // 1 day
+	maxStaleTTL                     = 86400 * 7 // RFC 8767 3: SHOULD NOT exceed one week
+	defaultStaleAnswerClientTimeout = 1800      // ms, RFC 8767 4 recommends ~1.8s
+	defaultPrefetchRatio            = 0.9
+
+	// topDomainsK is the Space-Saving top-K counter's tracked item count.
+	topDomainsK = 100
 )
 
 var _ sequence.RecursiveExecutable = (*cachePlugin)(nil)
 
 type Args struct {
 	Size              int    `yaml:"size"`
-	LazyCacheTTL      int    `yaml:"lazy_cache_ttl"`
+	LazyCacheTTL      int    `yaml:"lazy_cache_ttl"` // Deprecated: use StaleTTL.
 	LazyCacheReplyTTL int    `yaml:"lazy_cache_reply_ttl"`
 	DumpFile          string `yaml:"dump_file"`
 	DumpInterval      int    `yaml:"dump_interval"`
+	DnstapTag         string `yaml:"dnstap"` // tag of a dnstap plugin to tap cache events to. Optional.
+
+	// Backend selects the storage backend: "memory" (default) or "redis".
+	Backend        string `yaml:"backend"`
+	RedisURL       string `yaml:"redis_url"`
+	RedisPrefix    string `yaml:"redis_prefix"`
+	RedisPoolSize  int    `yaml:"redis_pool_size"`
+	RedisTwoTier   bool   `yaml:"redis_two_tier"` // front a small in-process LRU in front of redis
+	RedisFrontSize int    `yaml:"redis_front_size"`
+
+	// RFC 8767 serve-stale. StaleTTL bounds how long an expired record may
+	// still be served, capped at maxStaleTTL. StaleAnswerClientTimeout (ms)
+	// bounds how long Exec waits for a fresh upstream answer before falling
+	// back to the stale record.
+	StaleTTL                 int     `yaml:"stale_ttl"`
+	StaleAnswerClientTimeout int     `yaml:"stale_answer_client_timeout"`
+	PrefetchThreshold        int     `yaml:"prefetch_threshold"` // 0 disables prefetching.
+	PrefetchRatio            float64 `yaml:"prefetch_ratio"`
 }
 
 func (a *Args) init() {
 	utils.SetDefaultUnsignNum(&a.Size, 1024)
 	utils.SetDefaultUnsignNum(&a.LazyCacheReplyTTL, 5)
 	utils.SetDefaultUnsignNum(&a.DumpInterval, 600)
+
+	if len(a.Backend) == 0 {
+		a.Backend = "memory"
+	}
+	if len(a.RedisPrefix) == 0 {
+		a.RedisPrefix = "mosdns:cache:"
+	}
+	utils.SetDefaultUnsignNum(&a.RedisFrontSize, 256)
+
+	if a.StaleTTL <= 0 && a.LazyCacheTTL > 0 {
+		a.StaleTTL = a.LazyCacheTTL // migrate the old ad-hoc knob
+	}
+	utils.SetDefaultUnsignNum(&a.StaleTTL, defaultStaleTTL)
+	if a.StaleTTL > maxStaleTTL {
+		a.StaleTTL = maxStaleTTL
+	}
+	utils.SetDefaultUnsignNum(&a.StaleAnswerClientTimeout, defaultStaleAnswerClientTimeout)
+	if a.PrefetchRatio <= 0 || a.PrefetchRatio >= 1 {
+		a.PrefetchRatio = defaultPrefetchRatio
+	}
 }
 
 type cachePlugin struct {
 	*coremain.BP
 	args *Args
 
-	backend      *cache.Cache[key, *dns.Msg]
+	backend      cache.Backend[key, *dns.Msg]
 	lazyUpdateSF singleflight.Group
 	closeOnce    sync.Once
 	closeNotify  chan struct{}
 	updatedKey   atomic.Uint64
-
-	queryTotal   prometheus.Counter
-	hitTotal     prometheus.Counter
-	lazyHitTotal prometheus.Counter
-	size         prometheus.GaugeFunc
+	dnstap       pdnstap.Tapper // nil if Args.DnstapTag is empty
+
+	hitCounters sync.Map // msgKey string -> *hitCounter, for prefetch_threshold
+
+	prefetchMu    sync.Mutex
+	prefetchHeap  prefetchHeap
+	prefetchIndex map[string]*prefetchEntry
+	prefetchWake  chan struct{}
+
+	topDomains *topKCounter // approximate top queried domains, for /stats
+
+	// Plain running totals, kept alongside the labeled Prometheus vectors
+	// below so /stats can report hit ratios without summing label series.
+	queryCount   atomic.Uint64
+	hitCount     atomic.Uint64
+	lazyHitCount atomic.Uint64
+
+	queryTotal        *prometheus.CounterVec // labels: qtype, rcode
+	hitTotal          *prometheus.CounterVec // labels: qtype, rcode
+	lazyHitTotal      *prometheus.CounterVec // labels: qtype, rcode
+	staleServedTotal  prometheus.Counter
+	prefetchTotal     prometheus.Counter
+	prefetchRaceWon   prometheus.Counter
+	size              prometheus.GaugeFunc
+	lookupDuration    *prometheus.HistogramVec // labels: qtype, rcode
+	storeDuration     *prometheus.HistogramVec // labels: qtype, rcode
+	backendOpDuration *prometheus.HistogramVec // labels: backend, op ("get"/"store")
 }
 
 func Init(bp *coremain.BP, args interface{}) (coremain.Plugin, error) {
-	return newCachePlugin(bp, args.(*Args)), nil
+	return newCachePlugin(bp, args.(*Args))
 }
 
-func newCachePlugin(bp *coremain.BP, args *Args) *cachePlugin {
+func newCachePlugin(bp *coremain.BP, args *Args) (*cachePlugin, error) {
 	args.init()
 
-	backend := cache.New[key, *dns.Msg](cache.Opts{Size: args.Size})
+	var tapper pdnstap.Tapper
+	if len(args.DnstapTag) > 0 {
+		gotPlugin, ok := bp.M().GetPlugin(args.DnstapTag)
+		if !ok {
+			return nil, fmt.Errorf("cache: dnstap tag %q not found", args.DnstapTag)
+		}
+		tapper, ok = gotPlugin.(pdnstap.Tapper)
+		if !ok {
+			return nil, fmt.Errorf("cache: plugin %q is not a dnstap tapper", args.DnstapTag)
+		}
+	}
+
+	backend, err := newBackend(args)
+	if err != nil {
+		return nil, err
+	}
 
 	p := &cachePlugin{
-		BP:      bp,
-		args:    args,
-		backend: backend,
+		BP:          bp,
+		args:        args,
+		backend:     backend,
+		dnstap:      tapper,
+		closeNotify: make(chan struct{}),
+
+		prefetchIndex: make(map[string]*prefetchEntry),
+		prefetchWake:  make(chan struct{}, 1),
+		topDomains:    newTopKCounter(topDomainsK),
 
-		queryTotal: prometheus.NewCounter(prometheus.CounterOpts{
+		queryTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
 			Name: "query_total",
 			Help: "The total number of processed queries",
-		}),
-		hitTotal: prometheus.NewCounter(prometheus.CounterOpts{
+		}, []string{"qtype", "rcode"}),
+		hitTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
 			Name: "hit_total",
 			Help: "The total number of queries that hit the cache",
-		}),
-		lazyHitTotal: prometheus.NewCounter(prometheus.CounterOpts{
+		}, []string{"qtype", "rcode"}),
+		lazyHitTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
 			Name: "lazy_hit_total",
 			Help: "The total number of queries that hit the expired cache",
+		}, []string{"qtype", "rcode"}),
+		staleServedTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "stale_served_total",
+			Help: "The total number of queries answered with a stale (RFC 8767) record",
+		}),
+		prefetchTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "prefetch_total",
+			Help: "The total number of proactive prefetch refreshes fired",
+		}),
+		prefetchRaceWon: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "prefetch_race_won_total",
+			Help: "The total number of prefetch refreshes that completed before the record's natural expiry",
 		}),
 		size: prometheus.NewGaugeFunc(prometheus.GaugeOpts{
 			Name: "cache_size",
@@ -119,37 +238,87 @@ func newCachePlugin(bp *coremain.BP, args *Args) *cachePlugin {
 		}, func() float64 {
 			return float64(backend.Len())
 		}),
+		lookupDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "cache_lookup_duration_seconds",
+			Help:    "Latency of cache lookups",
+			Buckets: []float64{.00005, .0001, .00025, .0005, .001, .0025, .005, .01, .025, .05},
+		}, []string{"qtype", "rcode"}),
+		storeDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "cache_store_duration_seconds",
+			Help:    "Latency of cache stores",
+			Buckets: []float64{.00005, .0001, .00025, .0005, .001, .0025, .005, .01, .025, .05},
+		}, []string{"qtype", "rcode"}),
+		backendOpDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "cache_backend_op_duration_seconds",
+			Help:    "Latency of raw backend Get/Store calls",
+			Buckets: []float64{.00005, .0001, .00025, .0005, .001, .0025, .005, .01, .025, .05},
+		}, []string{"backend", "op"}),
 	}
-	bp.GetMetricsReg().MustRegister(p.queryTotal, p.hitTotal, p.lazyHitTotal, p.size)
+	bp.GetMetricsReg().MustRegister(p.queryTotal, p.hitTotal, p.lazyHitTotal, p.staleServedTotal, p.prefetchTotal, p.prefetchRaceWon, p.size, p.lookupDuration, p.storeDuration, p.backendOpDuration)
 
 	if err := p.loadDump(); err != nil {
 		p.L().Error("failed to load cache dump", zap.Error(err))
 	}
 	p.startDumpLoop()
+	p.startPrefetchLoop()
+	p.startHitCounterSweepLoop()
 
 	bp.RegAPI(p.api())
-	return p
+	return p, nil
+}
+
+// newBackend builds the cache.Backend[key, *dns.Msg] selected by
+// args.Backend ("memory" or "redis"; redis optionally fronted by an
+// in-process LRU via args.RedisTwoTier).
+func newBackend(args *Args) (cache.Backend[key, *dns.Msg], error) {
+	switch args.Backend {
+	case "", "memory":
+		return cache.NewMemory[key, *dns.Msg](cache.Opts{Size: args.Size}), nil
+	case "redis":
+		redisBackend, err := cache.NewRedis[key, *dns.Msg](
+			cache.RedisOpts{URL: args.RedisURL, Prefix: args.RedisPrefix, PoolSize: args.RedisPoolSize},
+			marshalKey, unmarshalKey, marshalValue, unmarshalValue,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("cache: failed to connect to redis: %w", err)
+		}
+		if args.RedisTwoTier {
+			return cache.NewTwoTier[key, *dns.Msg](redisBackend, args.RedisFrontSize), nil
+		}
+		return redisBackend, nil
+	default:
+		return nil, fmt.Errorf("cache: unknown backend %q", args.Backend)
+	}
 }
 
 func (c *cachePlugin) Exec(ctx context.Context, qCtx *query_context.Context, next sequence.ChainWalker) error {
-	c.queryTotal.Inc()
 	q := qCtx.Q()
+	qtype := qtypeLabel(q)
 
 	msgKey := getMsgKey(q)
 	if len(msgKey) == 0 { // skip cache
-		return next.ExecNext(ctx, qCtx)
+		err := next.ExecNext(ctx, qCtx)
+		c.countQuery(qtype, qCtx.R())
+		return err
 	}
+	c.topDomains.Add(q.Question[0].Name)
 
-	cachedResp, lazyHit := c.lookupCache(msgKey)
-	if lazyHit {
-		c.lazyHitTotal.Inc()
-		c.doLazyUpdate(msgKey, qCtx, next)
-	}
-	if cachedResp != nil { // cache hit
-		c.hitTotal.Inc()
+	lookupStart := time.Now()
+	cachedResp, res, storedTime, minTTL := c.lookupCache(msgKey)
+	c.lookupDuration.WithLabelValues(qtype, rcodeLabel(cachedResp)).Observe(time.Since(lookupStart).Seconds())
+
+	switch res {
+	case lookupFresh:
+		c.hitCount.Add(1)
+		c.hitTotal.WithLabelValues(qtype, rcodeLabel(cachedResp)).Inc()
 		cachedResp.Id = q.Id // change msg id
 		shuffleIP(cachedResp)
 		qCtx.SetResponse(cachedResp)
+		if c.args.PrefetchThreshold > 0 && c.recordHit(msgKey) >= c.args.PrefetchThreshold {
+			c.schedulePrefetch(msgKey, storedTime, minTTL, qCtx, next)
+		}
+	case lookupStale:
+		cachedResp = c.serveStale(qtype, msgKey, qCtx, next, cachedResp)
 	}
 
 	err := next.ExecNext(ctx, qCtx)
@@ -158,12 +327,106 @@ func (c *cachePlugin) Exec(ctx context.Context, qCtx *query_context.Context, nex
 	if r := qCtx.R(); cachedResp == nil && r != nil {
 		c.tryStoreMsg(msgKey, r)
 	}
+
+	c.countQuery(qtype, qCtx.R())
+
+	if c.dnstap != nil {
+		c.dnstap.TapQuery(qCtx)
+		c.dnstap.TapResponse(qCtx, cacheExtra(res))
+	}
 	return err
 }
 
-// doLazyUpdate starts a new goroutine to execute next node and update the cache in the background.
-// It has an inner singleflight.Group to de-duplicate same msgKey.
-func (c *cachePlugin) doLazyUpdate(msgKey string, qCtx *query_context.Context, next sequence.ChainWalker) {
+// countQuery updates the query_total counter and its plain running total,
+// used by /stats to compute hit ratios without summing label series.
+func (c *cachePlugin) countQuery(qtype string, r *dns.Msg) {
+	c.queryCount.Add(1)
+	c.queryTotal.WithLabelValues(qtype, rcodeLabel(r)).Inc()
+}
+
+// qtypeLabel buckets q's question type for metric labels: the common
+// A/AAAA/HTTPS types get their own series, everything else is OTHER.
+func qtypeLabel(q *dns.Msg) string {
+	if len(q.Question) != 1 {
+		return "OTHER"
+	}
+	switch q.Question[0].Qtype {
+	case dns.TypeA:
+		return "A"
+	case dns.TypeAAAA:
+		return "AAAA"
+	case dns.TypeHTTPS:
+		return "HTTPS"
+	default:
+		return "OTHER"
+	}
+}
+
+// rcodeLabel returns m's rcode name for metric labels, or "NONE" if m is nil
+// (e.g. a cache miss, or no response was produced yet).
+func rcodeLabel(m *dns.Msg) string {
+	if m == nil {
+		return "NONE"
+	}
+	if name, ok := dns.RcodeToString[m.Rcode]; ok {
+		return name
+	}
+	return "OTHER"
+}
+
+// cacheExtra classifies how qCtx's response was produced, for the
+// Dnstap.Extra field (see pkg/dnstap).
+func cacheExtra(res lookupResult) string {
+	switch res {
+	case lookupFresh:
+		return dnstap.ExtraCacheHit
+	case lookupStale:
+		return dnstap.ExtraLazyHit
+	default:
+		return dnstap.ExtraCacheMiss
+	}
+}
+
+// serveStale implements the RFC 8767 3.1 race: it starts an upstream
+// refresh via doLazyUpdate and waits up to args.StaleAnswerClientTimeout for
+// it. If the refresh wins the race, qCtx gets the fresh answer and none of
+// the lazy/stale counters move, since the client was never actually served
+// a stale record. Otherwise qCtx gets stale (its TTL already clamped by
+// lookupCache), lazyHitCount/lazyHitTotal/staleServedTotal are incremented
+// to reflect that, and the refresh keeps running in the background,
+// updating the cache once it completes.
+func (c *cachePlugin) serveStale(qtype, msgKey string, qCtx *query_context.Context, next sequence.ChainWalker, stale *dns.Msg) *dns.Msg {
+	ch := c.doLazyUpdate(msgKey, qCtx, next)
+	timeout := time.Duration(c.args.StaleAnswerClientTimeout) * time.Millisecond
+
+	select {
+	case res := <-ch:
+		if res.Err == nil {
+			if r, _ := res.Val.(*dns.Msg); r != nil {
+				fresh := r.Copy()
+				fresh.Id = qCtx.Q().Id
+				qCtx.SetResponse(fresh)
+				return fresh
+			}
+		}
+	case <-time.After(timeout):
+	}
+
+	c.lazyHitCount.Add(1)
+	c.lazyHitTotal.WithLabelValues(qtype, rcodeLabel(stale)).Inc()
+	c.staleServedTotal.Inc()
+	stale.Id = qCtx.Q().Id
+	shuffleIP(stale)
+	qCtx.SetResponse(stale)
+	return stale
+}
+
+// doLazyUpdate starts the upstream refresh for msgKey, de-duplicated by an
+// inner singleflight.Group, and updates the cache once it completes. The
+// returned channel delivers the refreshed *dns.Msg (or the error) to every
+// caller racing the same msgKey; callers that stop waiting on it do not
+// cancel the refresh, which keeps running to update the cache regardless.
+func (c *cachePlugin) doLazyUpdate(msgKey string, qCtx *query_context.Context, next sequence.ChainWalker) <-chan singleflight.Result {
 	qCtxCopy := qCtx.Copy()
 	lazyUpdateFunc := func() (interface{}, error) {
 		defer c.lazyUpdateSF.Forget(msgKey)
@@ -176,6 +439,7 @@ func (c *cachePlugin) doLazyUpdate(msgKey string, qCtx *query_context.Context, n
 		err := next.ExecNext(ctx, qCtx)
 		if err != nil {
 			c.L().Warn("failed to update lazy cache", qCtx.InfoField(), zap.Error(err))
+			return nil, err
 		}
 
 		r := qCtx.R()
@@ -183,42 +447,62 @@ func (c *cachePlugin) doLazyUpdate(msgKey string, qCtx *query_context.Context, n
 			c.tryStoreMsg(msgKey, r)
 		}
 		c.L().Debug("lazy cache updated", qCtx.InfoField())
-		return nil, nil
+		return r, nil
 	}
-	c.lazyUpdateSF.DoChan(msgKey, lazyUpdateFunc) // DoChan won't block this goroutine
+	return c.lazyUpdateSF.DoChan(msgKey, lazyUpdateFunc) // DoChan won't block this goroutine
 }
 
-// lookupCache returns the cached response. The ttl of returned msg will be changed properly.
+type lookupResult int
+
+const (
+	lookupMiss lookupResult = iota
+	lookupFresh
+	lookupStale
+)
+
+// lookupCache returns the cached response, if any. The ttl of the returned
+// msg will be changed properly. storedTime/minTTL are the record's original
+// values, needed by prefetch scheduling; they are zero on a miss.
 // Note: Caller SHOULD change the msg id because it's not same as query's.
-func (c *cachePlugin) lookupCache(msgKey string) (r *dns.Msg, lazyHit bool) {
+func (c *cachePlugin) lookupCache(msgKey string) (r *dns.Msg, res lookupResult, storedTime time.Time, minTTL uint32) {
 	// lookup in cache
+	getStart := time.Now()
 	v, storedTime, _, _ := c.backend.Get(key(msgKey))
+	c.backendOpDuration.WithLabelValues(c.args.Backend, "get").Observe(time.Since(getStart).Seconds())
+	if v == nil {
+		return nil, lookupMiss, time.Time{}, 0
+	}
 
-	// cache hit
-	if v != nil {
-		r = v.Copy()
-		msgTTL := time.Duration(dnsutils.GetMinimalTTL(r)) * time.Second
+	r = v.Copy()
+	minTTL = dnsutils.GetMinimalTTL(r)
+	msgTTL := time.Duration(minTTL) * time.Second
+	age := time.Since(storedTime)
 
-		// Not expired.
-		if storedTime.Add(msgTTL).After(time.Now()) {
-			dnsutils.SubtractTTL(r, uint32(time.Since(storedTime).Seconds()))
-			return r, false
-		}
-
-		// Expired but lazy update enabled and cached response has valid answer.
-		if c.args.LazyCacheTTL > 0 && r.Rcode == dns.RcodeSuccess && len(r.Answer) > 0 {
-			dnsutils.SetTTL(r, uint32(c.args.LazyCacheReplyTTL))
-			return r, true
-		}
-		// Expired negative response (NXDOMAIN, etc. ) should not be used.
+	// Not expired.
+	if age < msgTTL {
+		dnsutils.SubtractTTL(r, uint32(age.Seconds()))
+		return r, lookupFresh, storedTime, minTTL
 	}
 
-	// cache miss
-	return nil, false
+	// Expired but within the RFC 8767 staleness window and the cached
+	// response has a valid answer.
+	staleWindow := time.Duration(c.args.StaleTTL) * time.Second
+	if age < msgTTL+staleWindow && r.Rcode == dns.RcodeSuccess && len(r.Answer) > 0 {
+		dnsutils.SetTTL(r, uint32(c.args.LazyCacheReplyTTL))
+		return r, lookupStale, storedTime, minTTL
+	}
+	// Expired negative response (NXDOMAIN, etc.), or past the staleness
+	// window entirely, should not be used.
+	return nil, lookupMiss, time.Time{}, 0
 }
 
 // tryStoreMsg tries to store r to cache. If r should be cached.
 func (c *cachePlugin) tryStoreMsg(msgKey string, r *dns.Msg) {
+	storeStart := time.Now()
+	defer func() {
+		c.storeDuration.WithLabelValues(qtypeLabel(r), rcodeLabel(r)).Observe(time.Since(storeStart).Seconds())
+	}()
+
 	if r.Truncated != false {
 		return
 	}
@@ -238,11 +522,9 @@ func (c *cachePlugin) tryStoreMsg(msgKey string, r *dns.Msg) {
 			break
 		}
 
-		if c.args.LazyCacheTTL > 0 {
-			ttl = time.Duration(c.args.LazyCacheTTL) * time.Second
-		} else {
-			ttl = time.Duration(minTTL) * time.Second
-		}
+		// Keep the record around past its natural ttl so it can still be
+		// served stale (RFC 8767) up to args.StaleTTL after expiry.
+		ttl = time.Duration(minTTL)*time.Second + time.Duration(c.args.StaleTTL)*time.Second
 	default:
 		return
 	}
@@ -254,10 +536,13 @@ func (c *cachePlugin) tryStoreMsg(msgKey string, r *dns.Msg) {
 
 	c.updatedKey.Add(1)
 	now := time.Now()
+	backendStoreStart := time.Now()
 	c.backend.Store(key(msgKey), v, now, now.Add(ttl))
+	c.backendOpDuration.WithLabelValues(c.args.Backend, "store").Observe(time.Since(backendStoreStart).Seconds())
 }
 
 func (c *cachePlugin) Close() error {
+	c.closeOnce.Do(func() { close(c.closeNotify) })
 	if err := c.dumpCache(); err != nil {
 		c.L().Error("failed to dump cache", zap.Error(err))
 	}
@@ -355,9 +640,68 @@ func (c *cachePlugin) api() *chi.Mux {
 		}
 		w.WriteHeader(http.StatusOK)
 	})
+	r.Get("/stats", c.handleStats)
+	r.Get("/keys", c.handleKeys)
 	return r
 }
 
+// statsResponse is the /stats endpoint's JSON body.
+type statsResponse struct {
+	Size           int          `json:"size"`
+	Capacity       int          `json:"capacity"`
+	HitRatio       float64      `json:"hit_ratio"`
+	LazyHitRatio   float64      `json:"lazy_hit_ratio"`
+	EvictionsTotal uint64       `json:"evictions_total"`
+	TopDomains     []domainHits `json:"top_domains"`
+}
+
+func (c *cachePlugin) handleStats(w http.ResponseWriter, _ *http.Request) {
+	var hitRatio, lazyHitRatio float64
+	if queries := c.queryCount.Load(); queries > 0 {
+		hitRatio = float64(c.hitCount.Load()) / float64(queries)
+		lazyHitRatio = float64(c.lazyHitCount.Load()) / float64(queries)
+	}
+
+	var evictions uint64
+	if ec, ok := c.backend.(cache.EvictionCounter); ok {
+		evictions = ec.Evictions()
+	}
+
+	resp := statsResponse{
+		Size:           c.backend.Len(),
+		Capacity:       c.args.Size,
+		HitRatio:       hitRatio,
+		LazyHitRatio:   lazyHitRatio,
+		EvictionsTotal: evictions,
+		TopDomains:     c.topDomains.Top(topDomainsK),
+	}
+	w.Header().Set("content-type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// handleKeys serves GET /keys?prefix=&limit=, a debug endpoint listing
+// stored cache keys. limit defaults to 1000 and is not optional: an
+// unbounded dump of a large cache would be its own DoS vector.
+func (c *cachePlugin) handleKeys(w http.ResponseWriter, req *http.Request) {
+	const defaultKeysLimit = 1000
+
+	prefix := req.URL.Query().Get("prefix")
+	limit := defaultKeysLimit
+	if s := req.URL.Query().Get("limit"); len(s) > 0 {
+		if n, err := strconv.Atoi(s); err == nil && n > 0 {
+			limit = n
+		}
+	}
+
+	keys, err := c.backend.Keys(prefix, limit, marshalKey)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("content-type", "application/json")
+	_ = json.NewEncoder(w).Encode(keys)
+}
+
 // shuffle A/AAAA records in m.
 func shuffleIP(m *dns.Msg) {
 	ans := m.Answer
@@ -0,0 +1,194 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package cache
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/pkg/cache"
+	"github.com/IrineSistiana/mosdns/v5/pkg/dnstap"
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// newTestCachePlugin builds a cachePlugin with a real in-process backend,
+// bypassing newCachePlugin/coremain.BP: lookupCache and tryStoreMsg never
+// touch BP, so this is enough to exercise the actual cache logic.
+func newTestCachePlugin(t *testing.T) *cachePlugin {
+	t.Helper()
+	args := &Args{Backend: "memory"}
+	args.init()
+	histOpts := prometheus.HistogramOpts{Buckets: []float64{.001, .01, .1}}
+	return &cachePlugin{
+		args:              args,
+		backend:           cache.NewMemory[key, *dns.Msg](cache.Opts{Size: 64}),
+		lookupDuration:    prometheus.NewHistogramVec(histOpts, []string{"qtype", "rcode"}),
+		storeDuration:     prometheus.NewHistogramVec(histOpts, []string{"qtype", "rcode"}),
+		backendOpDuration: prometheus.NewHistogramVec(histOpts, []string{"backend", "op"}),
+	}
+}
+
+func newTestQuery(name string) *dns.Msg {
+	q := new(dns.Msg)
+	q.SetQuestion(dns.Fqdn(name), dns.TypeA)
+	return q
+}
+
+func newTestAnswer(q *dns.Msg, ttl uint32) *dns.Msg {
+	r := new(dns.Msg)
+	r.SetReply(q)
+	rr, err := dns.NewRR(q.Question[0].Name + " " + strconv.FormatUint(uint64(ttl), 10) + " IN A 127.0.0.1")
+	if err != nil {
+		panic(err)
+	}
+	r.Answer = append(r.Answer, rr)
+	return r
+}
+
+func TestLookupCache_MissThenFreshThenStale(t *testing.T) {
+	c := newTestCachePlugin(t)
+	q := newTestQuery("example.com.")
+	msgKey := getMsgKey(q)
+
+	if _, res, _, _ := c.lookupCache(msgKey); res != lookupMiss {
+		t.Fatalf("want lookupMiss on empty cache, got %v", res)
+	}
+
+	r := newTestAnswer(q, 1)
+	c.tryStoreMsg(msgKey, r)
+
+	gotFresh, res, _, minTTL := c.lookupCache(msgKey)
+	if res != lookupFresh {
+		t.Fatalf("want lookupFresh right after store, got %v", res)
+	}
+	if gotFresh == nil || len(gotFresh.Answer) == 0 {
+		t.Fatal("lookupCache returned no answer on a fresh hit")
+	}
+	if minTTL != 1 {
+		t.Fatalf("minTTL = %d, want 1", minTTL)
+	}
+
+	// Force the record into the RFC 8767 staleness window: stored so that
+	// its 1s TTL has long expired, but still within StaleTTL of expiry.
+	c.args.StaleTTL = 60
+	c.backend.Store(key(msgKey), r.Copy(), time.Now().Add(-2*time.Second), time.Now().Add(58*time.Second))
+
+	gotStale, res, _, _ := c.lookupCache(msgKey)
+	if res != lookupStale {
+		t.Fatalf("want lookupStale once past minTTL but within StaleTTL, got %v", res)
+	}
+	if gotStale == nil {
+		t.Fatal("lookupCache returned nil on a stale hit")
+	}
+
+	// Past minTTL+StaleTTL entirely, but not yet evicted by the backend
+	// itself: lookupCache's own staleness math must still call it a miss.
+	c.backend.Store(key(msgKey), r.Copy(), time.Now().Add(-120*time.Second), time.Now().Add(time.Hour))
+	if _, res, _, _ := c.lookupCache(msgKey); res != lookupMiss {
+		t.Fatalf("want lookupMiss once past the staleness window, got %v", res)
+	}
+}
+
+func TestTryStoreMsg_SkipsTruncatedAndUncacheableRcodes(t *testing.T) {
+	c := newTestCachePlugin(t)
+	q := newTestQuery("truncated.example.")
+	msgKey := getMsgKey(q)
+
+	r := newTestAnswer(q, 60)
+	r.Truncated = true
+	c.tryStoreMsg(msgKey, r)
+	if v, _, _, ok := c.backend.Get(key(msgKey)); ok || v != nil {
+		t.Fatal("a truncated response must not be cached")
+	}
+
+	r2 := newTestAnswer(q, 60)
+	r2.Truncated = false
+	r2.Rcode = dns.RcodeRefused
+	c.tryStoreMsg(msgKey, r2)
+	if v, _, _, ok := c.backend.Get(key(msgKey)); ok || v != nil {
+		t.Fatal("an uncacheable rcode (e.g. REFUSED) must not be cached")
+	}
+}
+
+func TestQtypeLabel(t *testing.T) {
+	tests := []struct {
+		qtype uint16
+		want  string
+	}{
+		{dns.TypeA, "A"},
+		{dns.TypeAAAA, "AAAA"},
+		{dns.TypeHTTPS, "HTTPS"},
+		{dns.TypeMX, "OTHER"},
+	}
+	for _, tt := range tests {
+		q := new(dns.Msg)
+		q.SetQuestion("example.com.", tt.qtype)
+		if got := qtypeLabel(q); got != tt.want {
+			t.Errorf("qtypeLabel(%d) = %q, want %q", tt.qtype, got, tt.want)
+		}
+	}
+}
+
+func TestRcodeLabel(t *testing.T) {
+	if got := rcodeLabel(nil); got != "NONE" {
+		t.Errorf("rcodeLabel(nil) = %q, want NONE", got)
+	}
+	m := new(dns.Msg)
+	m.Rcode = dns.RcodeNameError
+	if got := rcodeLabel(m); got != "NXDOMAIN" {
+		t.Errorf("rcodeLabel(NXDOMAIN) = %q, want NXDOMAIN", got)
+	}
+}
+
+func TestCacheExtra(t *testing.T) {
+	tests := []struct {
+		res  lookupResult
+		want string
+	}{
+		{lookupFresh, dnstap.ExtraCacheHit},
+		{lookupStale, dnstap.ExtraLazyHit},
+		{lookupMiss, dnstap.ExtraCacheMiss},
+	}
+	for _, tt := range tests {
+		if got := cacheExtra(tt.res); got != tt.want {
+			t.Errorf("cacheExtra(%v) = %q, want %q", tt.res, got, tt.want)
+		}
+	}
+}
+
+func TestShuffleIP_OnlyReordersTrailingAddressRecords(t *testing.T) {
+	m := new(dns.Msg)
+	cname, _ := dns.NewRR("example.com. 60 IN CNAME target.example.")
+	a1, _ := dns.NewRR("target.example. 60 IN A 127.0.0.1")
+	a2, _ := dns.NewRR("target.example. 60 IN A 127.0.0.2")
+	m.Answer = []dns.RR{cname, a1, a2}
+
+	shuffleIP(m)
+
+	if m.Answer[0] != cname {
+		t.Fatal("shuffleIP must not move the leading non-address record")
+	}
+	gotIPs := map[string]bool{m.Answer[1].(*dns.A).A.String(): true, m.Answer[2].(*dns.A).A.String(): true}
+	if !gotIPs["127.0.0.1"] || !gotIPs["127.0.0.2"] {
+		t.Fatalf("shuffleIP must preserve the address record set, got %v", m.Answer[1:])
+	}
+}
@@ -0,0 +1,60 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package cache
+
+import (
+	"fmt"
+
+	"github.com/miekg/dns"
+)
+
+// key is the cache backend's key type: a normalized representation of a
+// question, as produced by getMsgKey.
+type key string
+
+// getMsgKey returns q's cache key, or an empty string if q should not be
+// cached (e.g. it carries more than one question).
+func getMsgKey(q *dns.Msg) string {
+	if len(q.Question) != 1 {
+		return ""
+	}
+	qu := q.Question[0]
+	return fmt.Sprintf("%d %d %s", qu.Qtype, qu.Qclass, qu.Name)
+}
+
+func marshalKey(k key) ([]byte, error) {
+	return []byte(k), nil
+}
+
+func unmarshalKey(b []byte) (key, error) {
+	return key(b), nil
+}
+
+func marshalValue(m *dns.Msg) ([]byte, error) {
+	return m.Pack()
+}
+
+func unmarshalValue(b []byte) (*dns.Msg, error) {
+	m := new(dns.Msg)
+	if err := m.Unpack(b); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
@@ -0,0 +1,240 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package cache
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"go.uber.org/zap"
+)
+
+// hitWindow bounds the sliding window args.PrefetchThreshold is measured
+// over. A hit counter is reset once it has been alive longer than this.
+// hitCounterSweepInterval reuses it as the period between sweeps that drop
+// hitCounters entries that have gone cold, so the map stays bounded by
+// recently active msgKeys instead of growing with every one ever looked up.
+const hitWindow = time.Minute
+const hitCounterSweepInterval = hitWindow
+
+type hitCounter struct {
+	mu          sync.Mutex
+	windowStart time.Time
+	lastHit     time.Time
+	count       int
+}
+
+// recordHit bumps msgKey's hit counter and returns its count within the
+// current window.
+func (c *cachePlugin) recordHit(msgKey string) int {
+	now := time.Now()
+	v, _ := c.hitCounters.LoadOrStore(msgKey, &hitCounter{windowStart: now})
+	hc := v.(*hitCounter)
+
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	if now.Sub(hc.windowStart) > hitWindow {
+		hc.windowStart = now
+		hc.count = 0
+	}
+	hc.count++
+	hc.lastHit = now
+	return hc.count
+}
+
+// startHitCounterSweepLoop starts the background goroutine that drops
+// hitCounters entries that haven't had a hit in over hitWindow. It does not
+// block. Disabled entirely when PrefetchThreshold <= 0, same as the
+// prefetch loop it cleans up after.
+func (c *cachePlugin) startHitCounterSweepLoop() {
+	if c.args.PrefetchThreshold <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(hitCounterSweepInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				c.sweepHitCounters()
+			case <-c.closeNotify:
+				return
+			}
+		}
+	}()
+}
+
+// sweepHitCounters removes every hitCounters entry that has gone cold.
+func (c *cachePlugin) sweepHitCounters() {
+	now := time.Now()
+	c.hitCounters.Range(func(k, v interface{}) bool {
+		hc := v.(*hitCounter)
+		hc.mu.Lock()
+		cold := now.Sub(hc.lastHit) > hitWindow
+		hc.mu.Unlock()
+		if cold {
+			c.hitCounters.Delete(k)
+		}
+		return true
+	})
+}
+
+// prefetchEntry is a pending proactive refresh, ordered by deadline in
+// cachePlugin.prefetchHeap.
+type prefetchEntry struct {
+	msgKey   string
+	deadline time.Time // storedTime + minTTL*args.PrefetchRatio
+	natural  time.Time // storedTime + minTTL, the record's real expiry
+	qCtx     *query_context.Context
+	next     sequence.ChainWalker
+	index    int
+}
+
+type prefetchHeap []*prefetchEntry
+
+func (h prefetchHeap) Len() int            { return len(h) }
+func (h prefetchHeap) Less(i, j int) bool  { return h[i].deadline.Before(h[j].deadline) }
+func (h prefetchHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i]; h[i].index = i; h[j].index = j }
+func (h *prefetchHeap) Push(x interface{}) {
+	e := x.(*prefetchEntry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+func (h *prefetchHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	e := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return e
+}
+
+// schedulePrefetch (re)schedules a proactive refresh of msgKey at
+// storedTime + minTTL*args.PrefetchRatio, i.e. before the record naturally
+// expires. qCtx/next are copied/retained so the refresh can replay the
+// query through the rest of the sequence once the deadline fires.
+func (c *cachePlugin) schedulePrefetch(msgKey string, storedTime time.Time, minTTL uint32, qCtx *query_context.Context, next sequence.ChainWalker) {
+	natural := storedTime.Add(time.Duration(minTTL) * time.Second)
+	deadline := storedTime.Add(time.Duration(float64(minTTL) * c.args.PrefetchRatio * float64(time.Second)))
+	if !deadline.After(time.Now()) {
+		return // ttl too small relative to PrefetchRatio to be worth scheduling
+	}
+
+	c.prefetchMu.Lock()
+	defer c.prefetchMu.Unlock()
+	if e, ok := c.prefetchIndex[msgKey]; ok {
+		e.deadline = deadline
+		e.natural = natural
+		e.qCtx = qCtx.Copy()
+		e.next = next
+		heap.Fix(&c.prefetchHeap, e.index)
+		return
+	}
+	e := &prefetchEntry{msgKey: msgKey, deadline: deadline, natural: natural, qCtx: qCtx.Copy(), next: next}
+	heap.Push(&c.prefetchHeap, e)
+	c.prefetchIndex[msgKey] = e
+
+	select {
+	case c.prefetchWake <- struct{}{}:
+	default:
+	}
+}
+
+// startPrefetchLoop starts the background goroutine that fires due prefetch
+// entries. It does not block. Disabled entirely when PrefetchThreshold <= 0.
+func (c *cachePlugin) startPrefetchLoop() {
+	if c.args.PrefetchThreshold <= 0 {
+		return
+	}
+	go func() {
+		timer := time.NewTimer(time.Hour)
+		defer timer.Stop()
+		for {
+			wait := c.nextPrefetchWait()
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(wait)
+
+			select {
+			case <-timer.C:
+				c.firePrefetch()
+			case <-c.prefetchWake:
+			case <-c.closeNotify:
+				return
+			}
+		}
+	}()
+}
+
+func (c *cachePlugin) nextPrefetchWait() time.Duration {
+	c.prefetchMu.Lock()
+	defer c.prefetchMu.Unlock()
+	if c.prefetchHeap.Len() == 0 {
+		return time.Hour
+	}
+	if wait := time.Until(c.prefetchHeap[0].deadline); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// firePrefetch pops and runs every due entry.
+func (c *cachePlugin) firePrefetch() {
+	for {
+		c.prefetchMu.Lock()
+		if c.prefetchHeap.Len() == 0 || time.Now().Before(c.prefetchHeap[0].deadline) {
+			c.prefetchMu.Unlock()
+			return
+		}
+		e := heap.Pop(&c.prefetchHeap).(*prefetchEntry)
+		delete(c.prefetchIndex, e.msgKey)
+		c.prefetchMu.Unlock()
+
+		c.prefetchTotal.Inc()
+		go c.runPrefetch(e)
+	}
+}
+
+func (c *cachePlugin) runPrefetch(e *prefetchEntry) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultLazyUpdateTimeout)
+	defer cancel()
+
+	c.L().Debug("start prefetch", e.qCtx.InfoField())
+	if err := e.next.ExecNext(ctx, e.qCtx); err != nil {
+		c.L().Warn("prefetch failed", e.qCtx.InfoField(), zap.Error(err))
+		return
+	}
+	r := e.qCtx.R()
+	if r == nil {
+		return
+	}
+	c.tryStoreMsg(e.msgKey, r)
+	if time.Now().Before(e.natural) {
+		c.prefetchRaceWon.Inc()
+	}
+}
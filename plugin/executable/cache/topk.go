@@ -0,0 +1,90 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package cache
+
+import (
+	"sort"
+	"sync"
+)
+
+// domainHits pairs a domain name with its approximate query count, as
+// reported by the /stats endpoint's top_domains field.
+type domainHits struct {
+	Name string `json:"name"`
+	Hits uint64 `json:"hits"`
+}
+
+// topKCounter is a Space-Saving (Metwally et al.) approximate top-K counter:
+// it tracks at most k items and their counts in O(k) memory no matter how
+// many distinct domains are queried. Counts for items that were never
+// tracked exactly are overestimates, bounded by the count of the item they
+// displaced; good enough for an operator-facing "what's hot" view.
+type topKCounter struct {
+	mu    sync.Mutex
+	k     int
+	items map[string]uint64
+}
+
+func newTopKCounter(k int) *topKCounter {
+	return &topKCounter{k: k, items: make(map[string]uint64, k)}
+}
+
+// Add records one occurrence of name.
+func (t *topKCounter) Add(name string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.items[name]; ok {
+		t.items[name]++
+		return
+	}
+	if len(t.items) < t.k {
+		t.items[name] = 1
+		return
+	}
+
+	// At capacity: evict the minimum-count item and have the incoming item
+	// inherit its count plus one, the Space-Saving replacement rule.
+	var minName string
+	var minCount uint64 = ^uint64(0)
+	for n, c := range t.items {
+		if c < minCount {
+			minName, minCount = n, c
+		}
+	}
+	delete(t.items, minName)
+	t.items[name] = minCount + 1
+}
+
+// Top returns up to n tracked items, ordered by descending count.
+func (t *topKCounter) Top(n int) []domainHits {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	out := make([]domainHits, 0, len(t.items))
+	for name, count := range t.items {
+		out = append(out, domainHits{Name: name, Hits: count})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Hits > out[j].Hits })
+	if n > 0 && n < len(out) {
+		out = out[:n]
+	}
+	return out
+}
@@ -0,0 +1,47 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package cache
+
+import "testing"
+
+func TestTopKCounter_MostFrequentSurvives(t *testing.T) {
+	c := newTopKCounter(2)
+	for i := 0; i < 10; i++ {
+		c.Add("hot.example.")
+	}
+	c.Add("a.example.")
+	c.Add("b.example.")
+	c.Add("c.example.") // evicts one of a/b, k=2 is at capacity
+
+	top := c.Top(1)
+	if len(top) != 1 || top[0].Name != "hot.example." || top[0].Hits != 10 {
+		t.Fatalf("top = %+v, want [{hot.example. 10}]", top)
+	}
+}
+
+func TestTopKCounter_TopRespectsN(t *testing.T) {
+	c := newTopKCounter(10)
+	for _, name := range []string{"a.", "b.", "c."} {
+		c.Add(name)
+	}
+	if got := c.Top(2); len(got) != 2 {
+		t.Fatalf("len(Top(2)) = %d, want 2", len(got))
+	}
+}
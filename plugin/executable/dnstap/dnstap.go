@@ -0,0 +1,156 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package dnstap provides the "dnstap" executable plugin: it taps the
+// query/response currently held by qCtx and ships a dnstap frame to a
+// configured collector. It is deliberately side-effect free on the query
+// chain; Exec always calls next and never touches qCtx's response.
+package dnstap
+
+import (
+	"context"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/pkg/dnstap"
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/pkg/utils"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const PluginType = "dnstap"
+
+func init() {
+	coremain.RegNewPluginFunc(PluginType, Init, func() interface{} { return new(Args) })
+}
+
+type Args struct {
+	Target     string `yaml:"target"` // e.g. "unix:///var/run/mosdns/dnstap.sock" or "tcp://127.0.0.1:6000"
+	Identity   string `yaml:"identity"`
+	Version    string `yaml:"version"`
+	BufferSize int    `yaml:"buffer_size"`
+}
+
+func (a *Args) init() {
+	utils.SetDefaultUnsignNum(&a.BufferSize, 4096)
+}
+
+// Tapper is implemented by Plugin and can be looked up by other plugins
+// (e.g. cache) that want to tag their own dnstap frames, such as a
+// CACHE_HIT/CACHE_MISS/LAZY_HIT classification on the response event.
+type Tapper interface {
+	// TapQuery emits a RESOLVER_QUERY frame for qCtx's current query.
+	TapQuery(qCtx *query_context.Context)
+	// TapResponse emits a RESOLVER_RESPONSE frame for qCtx's current
+	// response, with extra set to one of dnstap.ExtraCacheHit,
+	// dnstap.ExtraCacheMiss or dnstap.ExtraLazyHit.
+	TapResponse(qCtx *query_context.Context, extra string)
+}
+
+var (
+	_ sequence.RecursiveExecutable = (*Plugin)(nil)
+	_ Tapper                       = (*Plugin)(nil)
+)
+
+type Plugin struct {
+	*coremain.BP
+	args *Args
+
+	w       *dnstap.Writer
+	dropped prometheus.Counter
+	sent    prometheus.Counter
+}
+
+func Init(bp *coremain.BP, args interface{}) (coremain.Plugin, error) {
+	return newPlugin(bp, args.(*Args)), nil
+}
+
+func newPlugin(bp *coremain.BP, args *Args) *Plugin {
+	args.init()
+
+	p := &Plugin{
+		BP:   bp,
+		args: args,
+		dropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "dnstap_dropped_total",
+			Help: "The total number of dnstap frames dropped because the sink was unavailable or the buffer was full",
+		}),
+		sent: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "dnstap_sent_total",
+			Help: "The total number of dnstap frames successfully delivered to the sink",
+		}),
+	}
+	bp.GetMetricsReg().MustRegister(p.dropped, p.sent)
+
+	p.w = dnstap.NewWriter(args.Target, args.Identity, args.Version, args.BufferSize, bp.L(), p.dropped, p.sent)
+	return p
+}
+
+// Exec taps qCtx as a CLIENT_QUERY/CLIENT_RESPONSE pair around next: the
+// query is tapped before next runs, since qCtx.R() is only populated once
+// next returns.
+func (p *Plugin) Exec(ctx context.Context, qCtx *query_context.Context, next sequence.ChainWalker) error {
+	p.tapQuery(qCtx, dnstap.MessageTypeClientQuery)
+	err := next.ExecNext(ctx, qCtx)
+	p.tapResponse(qCtx, dnstap.MessageTypeClientResponse, "")
+	return err
+}
+
+func (p *Plugin) TapQuery(qCtx *query_context.Context) {
+	p.tapQuery(qCtx, dnstap.MessageTypeResolverQuery)
+}
+
+func (p *Plugin) TapResponse(qCtx *query_context.Context, extra string) {
+	p.tapResponse(qCtx, dnstap.MessageTypeResolverResponse, extra)
+}
+
+func (p *Plugin) tapQuery(qCtx *query_context.Context, typ dnstap.MessageType) {
+	q := qCtx.Q()
+	if q == nil {
+		return
+	}
+	wire, err := q.Pack()
+	if err != nil {
+		return
+	}
+	m := dnstap.NewMessage(typ)
+	m.SetQuery(wire, qCtx.StartTime())
+	m.SetAddrs(qCtx.ClientAddr(), qCtx.ClientAddr())
+	p.w.Write(m)
+}
+
+func (p *Plugin) tapResponse(qCtx *query_context.Context, typ dnstap.MessageType, extra string) {
+	r := qCtx.R()
+	if r == nil {
+		return
+	}
+	wire, err := r.Pack()
+	if err != nil {
+		return
+	}
+	m := dnstap.NewMessage(typ)
+	m.SetResponse(wire, qCtx.StartTime())
+	m.SetAddrs(qCtx.ClientAddr(), qCtx.ClientAddr())
+	m.Extra = extra
+	p.w.Write(m)
+}
+
+func (p *Plugin) Close() error {
+	return p.w.Close()
+}
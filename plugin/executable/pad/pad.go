@@ -0,0 +1,182 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package pad implements the RFC 8467 EDNS(0) padding policy: it pads
+// queries and responses carried over an encrypted transport (DoT/DoH/DoQ)
+// to a block-length boundary, to make traffic analysis on message size
+// harder. Padding a plaintext (Do53) message instead leaks more than it
+// hides, so the plugin is a no-op unless the query arrived encrypted.
+package pad
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+
+	"github.com/IrineSistiana/mosdns/v5/coremain"
+	"github.com/IrineSistiana/mosdns/v5/pkg/dnsutils"
+	"github.com/IrineSistiana/mosdns/v5/pkg/query_context"
+	"github.com/IrineSistiana/mosdns/v5/plugin/executable/sequence"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const PluginType = "pad"
+
+func init() {
+	coremain.RegNewPluginFunc(PluginType, Init, func() interface{} { return new(Args) })
+}
+
+const (
+	modeStrict      = "strict"
+	modeRandomBlock = "random_block"
+	modeOff         = "off"
+
+	defaultQueryBlockLen    = 128
+	defaultResponseBlockLen = 468
+)
+
+// randomBlockCandidates are the factors random_block mode multiplies the
+// configured block length by, so the padded size itself doesn't become a
+// fixed fingerprint.
+var randomBlockCandidates = []int{1, 2, 3}
+
+type Args struct {
+	Mode             string `yaml:"mode"` // strict (default) | random_block | off
+	QueryBlockLen    int    `yaml:"query_block_len"`
+	ResponseBlockLen int    `yaml:"response_block_len"`
+	MinPadding       int    `yaml:"min_padding"`
+}
+
+func (a *Args) init() error {
+	if len(a.Mode) == 0 {
+		a.Mode = modeStrict
+	}
+	switch a.Mode {
+	case modeStrict, modeRandomBlock, modeOff:
+	default:
+		return fmt.Errorf("pad: invalid mode %q", a.Mode)
+	}
+	if a.QueryBlockLen <= 0 {
+		a.QueryBlockLen = defaultQueryBlockLen
+	}
+	if a.ResponseBlockLen <= 0 {
+		a.ResponseBlockLen = defaultResponseBlockLen
+	}
+	if a.MinPadding < 0 {
+		a.MinPadding = 0
+	}
+	return nil
+}
+
+var _ sequence.RecursiveExecutable = (*Plugin)(nil)
+
+type Plugin struct {
+	*coremain.BP
+	args *Args
+
+	paddingAdded *prometheus.CounterVec // labels: direction ("query"/"response"), transport
+}
+
+func Init(bp *coremain.BP, args interface{}) (coremain.Plugin, error) {
+	a := args.(*Args)
+	if err := a.init(); err != nil {
+		return nil, err
+	}
+
+	p := &Plugin{
+		BP:   bp,
+		args: a,
+		paddingAdded: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "padding_added_total",
+			Help: "The total number of messages that had EDNS0 padding added",
+		}, []string{"direction", "transport"}),
+	}
+	bp.GetMetricsReg().MustRegister(p.paddingAdded)
+	return p, nil
+}
+
+func (p *Plugin) Exec(ctx context.Context, qCtx *query_context.Context, next sequence.ChainWalker) error {
+	if p.args.Mode == modeOff {
+		return next.ExecNext(ctx, qCtx)
+	}
+
+	transport := transportLabel(qCtx.TransportSecurity())
+	if len(transport) == 0 { // plaintext Do53: padding would only add overhead, not privacy
+		return next.ExecNext(ctx, qCtx)
+	}
+
+	q := qCtx.Q()
+	if q.IsEdns0() != nil { // no OPT at all means the client never negotiated EDNS0; padding is meaningless
+		if _, added := dnsutils.PadToMinimum(q, p.target(q.Len(), p.args.QueryBlockLen)); added {
+			p.paddingAdded.WithLabelValues("query", transport).Inc()
+		}
+	}
+
+	err := next.ExecNext(ctx, qCtx)
+
+	if r := qCtx.R(); r != nil && r.IsEdns0() != nil {
+		if _, added := dnsutils.PadToMinimum(r, p.target(r.Len(), p.args.ResponseBlockLen)); added {
+			p.paddingAdded.WithLabelValues("response", transport).Inc()
+		}
+	}
+	return err
+}
+
+// target computes the wire length m should be padded up to: the smallest
+// multiple of blockLen that is both >= curLen and leaves room for the
+// PADDING option's 4 byte header plus at least args.MinPadding bytes of
+// actual padding. A message already on a boundary keeps curLen when
+// args.MinPadding is 0.
+func (p *Plugin) target(curLen, blockLen int) int {
+	blockLen = p.blockLen(blockLen)
+	if blockLen <= 0 {
+		return curLen + p.args.MinPadding
+	}
+	if p.args.MinPadding == 0 && curLen%blockLen == 0 {
+		return curLen
+	}
+	const paddingOptionHeaderLen = 4 // EDNS0 option code + option length
+	need := curLen + paddingOptionHeaderLen + p.args.MinPadding
+	return ((need + blockLen - 1) / blockLen) * blockLen
+}
+
+// blockLen returns base in strict mode. In random_block mode it scales base
+// by a small random factor, so the padded size isn't itself a fixed, easily
+// fingerprinted value.
+func (p *Plugin) blockLen(base int) int {
+	if p.args.Mode != modeRandomBlock {
+		return base
+	}
+	return base * randomBlockCandidates[rand.Intn(len(randomBlockCandidates))]
+}
+
+// transportLabel returns ts's metric label, or "" if ts is not an encrypted
+// transport (padding should only ever apply to DoT/DoH/DoQ).
+func transportLabel(ts query_context.TransportSecurity) string {
+	switch ts {
+	case query_context.TransportTLS:
+		return "dot"
+	case query_context.TransportHTTPS:
+		return "doh"
+	case query_context.TransportQUIC:
+		return "doq"
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,67 @@
+/*
+ * Copyright (C) 2020-2022, IrineSistiana
+ *
+ * This file is part of mosdns.
+ *
+ * mosdns is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * mosdns is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package pad
+
+import "testing"
+
+func TestArgs_init(t *testing.T) {
+	a := new(Args)
+	if err := a.init(); err != nil {
+		t.Fatal(err)
+	}
+	if a.Mode != modeStrict || a.QueryBlockLen != defaultQueryBlockLen || a.ResponseBlockLen != defaultResponseBlockLen {
+		t.Fatalf("unexpected defaults: %+v", a)
+	}
+
+	if err := (&Args{Mode: "bogus"}).init(); err == nil {
+		t.Fatal("expected an error for an invalid mode")
+	}
+}
+
+func TestPlugin_target_BlockBoundaries(t *testing.T) {
+	p := &Plugin{args: &Args{Mode: modeStrict}}
+
+	tests := []struct {
+		curLen, blockLen int
+		want             int
+	}{
+		{127, 128, 256}, // the 4 byte PADDING option header alone overshoots 128
+		{128, 128, 128}, // already exactly on a boundary: no extra padding needed
+		{129, 128, 256},
+		{467, 468, 936},
+		{468, 468, 468},
+		{469, 468, 936},
+	}
+	for _, tt := range tests {
+		if got := p.target(tt.curLen, tt.blockLen); got != tt.want {
+			t.Errorf("target(%d, %d) = %d, want %d", tt.curLen, tt.blockLen, got, tt.want)
+		}
+	}
+}
+
+func TestPlugin_target_MinPadding(t *testing.T) {
+	p := &Plugin{args: &Args{Mode: modeStrict, MinPadding: 1}}
+
+	// Already on a boundary, but min_padding forces at least one more byte,
+	// which rounds up to the next block.
+	if got := p.target(128, 128); got != 256 {
+		t.Fatalf("target(128, 128) with min_padding=1 = %d, want 256", got)
+	}
+}